@@ -1,17 +1,44 @@
 package frame
 
+import "bytes"
 import "io"
 import "fmt"
+import "unicode/utf8"
 
+// Box holds a run of UTF-8 encoded text. Its data never
+// begins or ends in the middle of an encoded rune.
+//
+// A break box holds a single newline or tab and nothing else.
+// Its width depends on where it is placed on the line and is
+// assigned by Dot.InsertBox.
 type Box struct {
 	data  []byte
+	nrune int
 	width int
+	bc    rune
 }
 
+// IsBreak returns true if b is a newline or tab box
+func (b *Box) IsBreak() bool {
+	return b.bc != 0
+}
+
+// BreakChar returns the newline or tab held by a break box,
+// or zero for a text box
+func (b *Box) BreakChar() rune {
+	return b.bc
+}
+
+// Len returns the number of bytes in the box
 func (b *Box) Len() int {
 	return len(b.data)
 }
 
+// Nrune returns the number of runes in the box
+func (b *Box) Nrune() int {
+	return b.nrune
+}
+
 func (b *Box) Cap() int {
 	return cap(b.data)
 }
@@ -26,73 +53,213 @@ type Boxes struct {
 }
 
 func NewBoxes(measure func([]byte) int) *Boxes {
-	b := &Boxes{measure: measure}
-	b.Add(25)
-	return b
+	return &Boxes{measure: measure}
+}
+
+// ReadAt implements io.ReaderAt. It returns io.EOF if fewer
+// than len(p) bytes remain after off.
+func (b *Boxes) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, ErrBadOffset
+	}
+	i := 0
+	for _, bp := range b.Box {
+		if n == len(p) {
+			break
+		}
+		w := len(bp.data)
+		if int64(i+w) > off {
+			n += copy(p[n:], bp.data[max(int(off)-i, 0):])
+		}
+		i += w
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+	return n, err
 }
 
+// WriteAt implements io.WriterAt. It overwrites the bytes
+// starting at off with p, extending the boxes if p runs past
+// the end. Use InsertAt to insert without overwriting.
 func (b *Boxes) WriteAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off > int64(b.Len()) {
+		return 0, ErrBadOffset
+	}
+	b.DeleteRange(int(off), int(off)+len(p))
 	b.Insert(p, int(off))
 	return len(p), nil
 }
 
-func (b *Boxes) ReadAt(p []byte, off int64) (n int, err error) {
-	bn, err := b.Find(0, 0, int(off))
-	fmt.Println("offset %d found in box %d", off, bn)
-	if err != nil {
-		return n, err
+// InsertAt inserts p at offset off, shifting the bytes after
+// off to the right.
+func (b *Boxes) InsertAt(p []byte, off int64) (n int, err error) {
+	if off < 0 || off > int64(b.Len()) {
+		return 0, ErrBadOffset
 	}
-	bp := b.Box[bn]
-	for need := len(p); need > 0; {
-		m := copy(p[n:], bp.data[:min(len(bp.data), need)])
-		n += m
-		need -= m
-		bn++
-		if bn >= len(b.Box) {
-			return n, io.ErrUnexpectedEOF
+	b.Insert(p, int(off))
+	return len(p), nil
+}
+
+// WriteTo implements io.WriterTo. It writes the contents of
+// the boxes to w.
+func (b *Boxes) WriteTo(w io.Writer) (n int64, err error) {
+	for _, bp := range b.Box {
+		m, err := w.Write(bp.data)
+		n += int64(m)
+		if err != nil {
+			return n, err
 		}
-		bp = b.Box[bn]
 	}
 	return n, nil
 }
 
+// ReadFrom implements io.ReaderFrom. It appends the data read
+// from r until io.EOF to the end of the boxes.
+func (b *Boxes) ReadFrom(r io.Reader) (n int64, err error) {
+	var buf [8192]byte
+	for {
+		m, err := r.Read(buf[:])
+		if m > 0 {
+			b.Insert(buf[:m], b.Len())
+			n += int64(m)
+		}
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Insert inserts p at offset off. Text is appended to the text
+// box ending at off and every newline or tab in p is placed in
+// a break box of its own.
 func (b *Boxes) Insert(p []byte, off int) {
 	n, err := b.Find(0, 0, off)
 	if err != nil {
 		panic("insert")
 	}
-	if n >= len(b.Box) {
-		n = len(b.Box) - 1
-		b.Add(1)
+	for len(p) > 0 {
+		i := bytes.IndexAny(p, "\n\t")
+		if i == 0 {
+			b.insertbox(n, p[:1])
+			n++
+			p = p[1:]
+			continue
+		}
+		if i < 0 {
+			i = len(p)
+		}
+		if n > 0 && !b.Box[n-1].IsBreak() {
+			bp := b.Box[n-1]
+			bp.data = append(bp.data, p[:i]...)
+			b.remeasure(bp)
+		} else {
+			b.insertbox(n, p[:i])
+			n++
+		}
+		p = p[i:]
+	}
+}
+
+// insertbox inserts a new box holding a copy of p before box n
+func (b *Boxes) insertbox(n int, p []byte) {
+	bp := &Box{data: append(make([]byte, 0, len(p)), p...)}
+	b.remeasure(bp)
+	b.Box = append(b.Box[:n], append([]*Box{bp}, b.Box[n:]...)...)
+}
+
+// remeasure recomputes the rune count and width of box bp.
+// Break boxes are given zero width until they are placed.
+func (b *Boxes) remeasure(bp *Box) {
+	bp.nrune = utf8.RuneCount(bp.data)
+	bp.bc = 0
+	if len(bp.data) == 1 && (bp.data[0] == '\n' || bp.data[0] == '\t') {
+		bp.bc = rune(bp.data[0])
+	}
+	if bp.IsBreak() {
+		bp.width = 0
+		return
 	}
-	bp := b.Box[n]
-	bp.data = append(bp.data, p...)
 	bp.width = b.measure(bp.data)
 }
 
-// Find starts at box n, assuming offset i, and
-// advances to offset j. It returns the box number
-// containing offset j, guaraneed to align on a box
-// boundary.
+// NextRune returns the offset of the rune following the
+// one starting at offset off. It returns off if there are
+// no more runes.
+func (b *Boxes) NextRune(off int) int {
+	i := 0
+	for _, bp := range b.Box {
+		if off < i+len(bp.data) {
+			_, size := utf8.DecodeRune(bp.data[off-i:])
+			return off + size
+		}
+		i += len(bp.data)
+	}
+	return off
+}
+
+// PrevRune returns the offset of the rune preceding offset
+// off. It returns off if off is the first offset.
+func (b *Boxes) PrevRune(off int) int {
+	i := 0
+	for _, bp := range b.Box {
+		if off <= i+len(bp.data) && off > i {
+			_, size := utf8.DecodeLastRune(bp.data[:off-i])
+			return off - size
+		}
+		i += len(bp.data)
+	}
+	return off
+}
+
+// Find starts at box n, assuming box n begins at offset i, and
+// advances to offset j. It returns the number of the box
+// beginning at offset j, splitting the box containing j if
+// necessary. If j lies at or beyond the end of the last box,
+// len(b.Box) is returned.
 func (b *Boxes) Find(n, i, j int) (int, error) {
-	fmt.Printf("Boxes.Find: n=%d i=%d j=%d\n", n, i, j)
-	for _, v := range b.Box {
-		w := len(v.data)
+	for ; n < len(b.Box); n++ {
+		w := len(b.Box[n].data)
 		if i+w > j {
-			break
+			if j > i {
+				b.Split(n, j-i)
+				n++
+			}
+			return n, nil
 		}
 		i += w
 	}
-	if i < j {
-		fmt.Printf("Boxes.Find: split %d,%d\n", n+1, j-i)
-		b.Split(n+1, j-i)
+	return len(b.Box), nil
+}
+
+// Len returns the number of bytes stored in the boxes
+func (b *Boxes) Len() (n int) {
+	for _, bp := range b.Box {
+		n += len(bp.data)
 	}
-	fmt.Printf("Boxes.Find: ret %d\n", n+1)
-	return n + 1, nil
+	return n
+}
+
+// Slice returns a copy of the bytes in the range [i, j)
+func (b *Boxes) Slice(i, j int) []byte {
+	p := make([]byte, 0, j-i)
+	off := 0
+	for _, bp := range b.Box {
+		if off >= j {
+			break
+		}
+		if w := len(bp.data); off+w > i {
+			p = append(p, bp.data[max(i-off, 0):min(j-off, w)]...)
+		}
+		off += len(bp.data)
+	}
+	return p
 }
 
 func (b *Boxes) Split(n int, at int) {
-	fmt.Printf("Boxes.Split: n=%d at=%d\n", n, at)
 	b.Dup(n)
 	//b.Truncate(n, len(box.data)-at+1)
 	b.Truncate(n, at)
@@ -111,6 +278,8 @@ func (b *Boxes) Dup(n int) *Box {
 	bp0 := b.Box[n]
 	bp1 := &Box{
 		width: bp0.width,
+		nrune: bp0.nrune,
+		bc:    bp0.bc,
 		data:  append([]byte{}, bp0.data...),
 	}
 	b.Box = append(b.Box[:n+1], append([]*Box{bp1}, b.Box[n+1:]...)...)
@@ -118,29 +287,94 @@ func (b *Boxes) Dup(n int) *Box {
 	return bp1
 }
 
-// Truncate discards all but the first n bytes of box bn
+// Truncate discards all but the first n bytes of box bn. The
+// offset n must lie on a rune boundary.
 func (b *Boxes) Truncate(bn, n int) {
 	box := b.Box[bn]
 	box.data = box.data[:n]
-	box.width = b.measure(box.data)
+	b.remeasure(box)
 }
 
-// Chop discards the first n bytes of box bn
+// Chop discards the first n bytes of box bn. The offset n
+// must lie on a rune boundary.
 func (b *Boxes) Chop(bn, n int) {
 	box := b.Box[bn]
 	copy(box.data, box.data[n:])
 	box.data = box.data[:len(box.data)-n]
-	box.width = b.measure(box.data)
+	b.remeasure(box)
 }
 
+// Merge appends the contents of box n+1 to box n and
+// removes box n+1
 func (b *Boxes) Merge(n int) {
 	sp := b.Box[n:]
 	sp[0].data = append(sp[0].data, sp[1].data...)
-	sp[0].width += sp[1].width
+	b.remeasure(sp[0])
+	b.Delete(n+1, n+2)
+}
+
+// DeleteRange removes the bytes in the range [i, j). The
+// boxes containing i and j are split on the boundary, the
+// boxes between them removed, and the two remaining
+// neighbours coalesced.
+func (b *Boxes) DeleteRange(i, j int) {
+	if n := b.Len(); j > n {
+		j = n
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i >= j {
+		return
+	}
+	bi, _ := b.Find(0, 0, i)
+	bj, _ := b.Find(bi, i, j)
+	b.Delete(bi, bj)
+	if bi > 0 && bi < len(b.Box) && !b.Box[bi-1].IsBreak() && !b.Box[bi].IsBreak() {
+		b.Merge(bi - 1)
+	}
+}
+
+// Clean coalesces adjacent text boxes in the range [n0, n1)
+// that lie on the same line and removes empty boxes. Dot
+// must be positioned where box n0 begins.
+func (b *Boxes) Clean(dot *Dot, n0, n1 int) {
+	n1 = min(n1, len(b.Box))
+	for n := n0; n < n1; n++ {
+		bp := b.Box[n]
+		if bp.Len() == 0 {
+			b.Delete(n, n+1)
+			n, n1 = n-1, n1-1
+			continue
+		}
+		sp := dot.InsertBox(bp)
+		for !bp.IsBreak() && n+1 < n1 {
+			next := b.Box[n+1]
+			if next.IsBreak() || next.Width() > dot.remaining() {
+				break
+			}
+			// the merged box can be narrower than its parts
+			// if the glyphs where they meet are kerned
+			b.Merge(n)
+			dot.X = sp.X + bp.Width()
+			n1--
+		}
+	}
+}
+
+// join merges each run of adjacent text boxes from box n on
+// into a single box
+func (b *Boxes) join(n int) {
+	for ; n+1 < len(b.Box); n++ {
+		for n+1 < len(b.Box) && !b.Box[n].IsBreak() && !b.Box[n+1].IsBreak() {
+			b.Merge(n)
+		}
+	}
 }
 
+// Delete removes boxes n0 up to, but not including, n1
 func (b *Boxes) Delete(n0, n1 int) {
-	dn := len(b.Box[n1:]) - len(b.Box[n0:])
+	dn := n1 - n0
 	copy(b.Box[n0:], b.Box[n1:])
 	b.Box = b.Box[:len(b.Box)-dn]
 }
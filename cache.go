@@ -0,0 +1,155 @@
+package frame
+
+import (
+	"container/list"
+	"image"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/math/fixed"
+)
+
+// DefaultCacheSize is the number of bytes of glyphs a Font
+// caches if its CacheSize is zero
+const DefaultCacheSize = 4 << 20
+
+// glyphkey identifies a cached glyph. A key with a negative
+// face holds the result of Font.lookup for r instead of a
+// glyph.
+type glyphkey struct {
+	face int
+	r    rune
+	sub  fixed.Point26_6
+}
+
+// glyph is a rasterized glyph or the result of a lookup
+type glyph struct {
+	key glyphkey
+
+	// dr is where the mask is drawn relative to the integer
+	// part of the dot
+	dr   image.Rectangle
+	mask *image.Alpha
+	ok   bool
+
+	n   int
+	rr  rune
+	adv fixed.Int26_6
+}
+
+// size returns the approximate number of bytes g holds
+func (g *glyph) size() int {
+	n := 64
+	if g.mask != nil {
+		n += len(g.mask.Pix)
+	}
+	return n
+}
+
+// glyphcache holds the most recently used glyphs up to a
+// number of bytes. The least recently used are evicted first.
+type glyphcache struct {
+	mu   sync.Mutex
+	max  int
+	size int
+	m    map[glyphkey]*list.Element
+	lru  list.List
+}
+
+func newGlyphCache(max int) *glyphcache {
+	return &glyphcache{max: max, m: make(map[glyphkey]*list.Element)}
+}
+
+func (c *glyphcache) get(k glyphkey) (*glyph, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.m[k]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*glyph), true
+}
+
+func (c *glyphcache) put(g *glyph) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.m[g.key]; ok {
+		c.size -= e.Value.(*glyph).size()
+		c.lru.Remove(e)
+	}
+	c.m[g.key] = c.lru.PushFront(g)
+	c.size += g.size()
+	for c.size > c.max && c.lru.Len() > 0 {
+		e := c.lru.Back()
+		old := e.Value.(*glyph)
+		c.lru.Remove(e)
+		delete(c.m, old.key)
+		c.size -= old.size()
+	}
+}
+
+// cache returns the font's glyph cache, or nil if caching is
+// disabled
+func (f *Font) cache() *glyphcache {
+	if f.CacheSize < 0 {
+		return nil
+	}
+	if f.glyphs == nil {
+		max := f.CacheSize
+		if max == 0 {
+			max = DefaultCacheSize
+		}
+		f.glyphs = newGlyphCache(max)
+	}
+	return f.glyphs
+}
+
+// subpixel splits the dot into its integer part and its
+// fraction, rounded to a quarter pixel
+func subpixel(dot fixed.Point26_6) (image.Point, fixed.Point26_6) {
+	pt := image.Pt(dot.X.Floor(), dot.Y.Floor())
+	sub := dot.Sub(fixed.P(pt.X, pt.Y))
+	sub.X &^= 15
+	sub.Y &^= 15
+	return pt, sub
+}
+
+// rasterize returns the glyph for rune r of face n drawn at
+// the fraction sub of a pixel, copying the face's mask so it
+// survives the face's next call
+func (f *Font) rasterize(n int, r rune, sub fixed.Point26_6) *glyph {
+	g := &glyph{key: glyphkey{n, r, sub}}
+	dr, mask, maskp, _, ok := f.face(n).Glyph(sub, r)
+	if !ok {
+		return g
+	}
+	g.ok = true
+	g.dr = dr
+	g.mask = image.NewAlpha(image.Rectangle{Max: dr.Size()})
+	draw.Draw(g.mask, g.mask.Bounds(), mask, maskp, draw.Src)
+	return g
+}
+
+// drawglyph draws the glyph for r with its dot at dot, taking
+// it from the cache if it's there
+func (f *Font) drawglyph(dst draw.Image, dot fixed.Point26_6, r rune, src image.Image, sp image.Point) {
+	n, r, _ := f.lookup(r)
+	c := f.cache()
+	if c == nil {
+		if dr, mask, maskp, _, ok := f.face(n).Glyph(dot, r); ok {
+			draw.DrawMask(dst, dr, src, sp, mask, maskp, draw.Over)
+		}
+		return
+	}
+	pt, sub := subpixel(dot)
+	k := glyphkey{n, r, sub}
+	g, ok := c.get(k)
+	if !ok {
+		g = f.rasterize(n, r, sub)
+		c.put(g)
+	}
+	if g.ok {
+		draw.DrawMask(dst, g.dr.Add(pt), src, sp, g.mask, image.ZP, draw.Over)
+	}
+}
@@ -2,39 +2,160 @@ package frame
 
 import (
 	"bytes"
+	"fmt"
 	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
 	"image"
+	"unicode"
+	"unicode/utf8"
 )
 
+// Font is a face and the faces that glyphs missing from it
+// are taken from. A glyph missing from every face is replaced
+// by U+FFFD, or by a question mark if that's missing too.
 type Font struct {
 	font.Face
+
+	// Fallback is searched in order for glyphs that Face
+	// doesn't have. It shouldn't change once the font is used.
+	Fallback []font.Face
+
+	// CacheSize is the number of bytes of rasterized glyphs
+	// kept for reuse. Zero means DefaultCacheSize and a
+	// negative size disables the cache.
+	CacheSize int
+
 	height int
+	colw   int
+	glyphs *glyphcache
 }
 
-func NewFont(face font.Face) *Font {
+func NewFont(face font.Face, fallback ...font.Face) *Font {
 	if face == nil {
 		panic("NewFont: nil font face")
 	}
 	return &Font{
-		Face: face,
+		Face:     face,
+		Fallback: fallback,
+	}
+}
+
+// face returns face n of the font. Face zero is the primary
+// face and the fallbacks follow it.
+func (f *Font) face(n int) font.Face {
+	if n == 0 {
+		return f.Face
+	}
+	return f.Fallback[n-1]
+}
+
+// lookup returns the number of the face that draws r, the
+// rune it draws in place of r, and the glyph's advance
+func (f *Font) lookup(r rune) (n int, rr rune, adv fixed.Int26_6) {
+	c := f.cache()
+	if c == nil {
+		return f.search(r)
+	}
+	k := glyphkey{face: -1, r: r}
+	if g, ok := c.get(k); ok {
+		return g.n, g.rr, g.adv
+	}
+	n, rr, adv = f.search(r)
+	c.put(&glyph{key: k, n: n, rr: rr, adv: adv})
+	return n, rr, adv
+}
+
+// search is lookup without the cache
+func (f *Font) search(r rune) (n int, rr rune, adv fixed.Int26_6) {
+	for _, rr = range [...]rune{r, utf8.RuneError} {
+		for n = 0; n <= len(f.Fallback); n++ {
+			if dx, ok := f.face(n).GlyphAdvance(rr); ok {
+				return n, rr, dx
+			}
+		}
+	}
+	dx, _ := f.Face.GlyphAdvance('?')
+	return 0, '?', dx
+}
+
+// kern returns the kerning between r0 and r1, or zero if they
+// are drawn from different faces
+func (f *Font) kern(r0, r1 rune) fixed.Int26_6 {
+	n0, r0, _ := f.lookup(r0)
+	n1, r1, _ := f.lookup(r1)
+	if n0 != n1 {
+		return 0
 	}
+	return f.face(n0).Kern(r0, r1)
 }
 
+// Height returns the height of a line of text without leading.
+// It's never less than the face's ascent plus its descent, so
+// descenders don't reach into the line below.
 func (f *Font) Height() int {
 	if f.Face == nil {
 		return 0
 	}
 	if f.height == 0 {
-		f.height = int(f.Metrics().Height>>6) + 1
+		m := f.Metrics()
+		f.height = max(int(m.Height>>6)+1, m.Ascent.Ceil()+m.Descent.Ceil())
 	}
 	return f.height
 }
 
+// baseline returns the distance from the top of a line to
+// the baseline of its glyphs. A line can be taller than the
+// face's ascent plus its descent, and the glyphs are centered
+// between its top and bottom.
+func (f *Font) baseline() int {
+	m := f.Metrics()
+	ascent, descent := m.Ascent.Ceil(), m.Descent.Ceil()
+	return max(f.Height()-ascent-descent, 0)/2 + ascent
+}
+
+// ColumnWidth returns the width in pixels of a column of text.
+// For a monospace face it's the advance of every glyph, and
+// otherwise the average advance of the printable ASCII glyphs.
+func (f *Font) ColumnWidth() int {
+	if f.colw == 0 {
+		var sum, n fixed.Int26_6
+		for r := rune(' '); r <= '~'; r++ {
+			if dx, ok := f.GlyphAdvance(r); ok {
+				sum += dx
+				n++
+			}
+		}
+		if n > 0 {
+			f.colw = int((sum / n) >> 6)
+		}
+	}
+	return f.colw
+}
+
 type Dot struct {
 	image.Point
 	origin image.Point
 	maxw   int
 	font   *Font
+
+	// tab is the distance between tab stops in pixels. Zero
+	// means four spaces.
+	tab int
+
+	// elastic holds the widths of tabs sized by elastic
+	// tabstops. Tabs missing from it stop at the next multiple
+	// of tab.
+	elastic map[*Box]int
+
+	// kerning is true if pairs of glyphs are kerned
+	kerning bool
+
+	// leading is the number of pixels added to the font's
+	// height between lines
+	leading int
+
+	// control is the way control characters are drawn
+	control ControlMode
 }
 
 func NewDot(origin image.Point, maxw int, font *Font) *Dot {
@@ -50,17 +171,65 @@ func NewDot(origin image.Point, maxw int, font *Font) *Dot {
 }
 
 func (d *Dot) advance(r rune) int {
-	dx, _ := d.font.GlyphAdvance(r)
+	_, _, dx := d.font.lookup(r)
 	return int(dx >> 6)
 }
 
+// Advance returns the number of pixels dot would advance if
+// r were inserted. A tab advances to the next tab stop, which
+// is measured from the dot's origin.
 func (d *Dot) Advance(r rune) int {
 	if r == '\t' {
-		return d.advance(' ') * 4
+		tab := d.tab
+		if tab <= 0 {
+			tab = d.advance(' ') * 4
+		}
+		if tab <= 0 {
+			return 0
+		}
+		return tab - d.Width()%tab
+	}
+	if s, pad := d.symbol(r); s != "" {
+		w := 2 * pad
+		for _, c := range s {
+			w += d.advance(c)
+		}
+		return w
 	}
 	return d.advance(r)
 }
 
+// hexpad is the space on each side of the digits in a hex box
+const hexpad = 2
+
+// symbol returns the text drawn in place of the control
+// character r and the padding on each side of it, or an empty
+// string if r is drawn from the font
+func (d *Dot) symbol(r rune) (s string, pad int) {
+	if d.control == ControlGlyph || r == '\t' || r == '\n' || !unicode.IsControl(r) {
+		return "", 0
+	}
+	if d.control == ControlCaret && (r < 0x20 || r == 0x7f) {
+		return string([]rune{'^', r ^ 0x40}), 0
+	}
+	return fmt.Sprintf("%02X", r), hexpad
+}
+
+// pair returns the kerning adjustment in pixels between the
+// runes prev and r. A negative prev is the start of a run.
+func (d *Dot) pair(prev, r rune) int {
+	if !d.kerning || prev < 0 {
+		return 0
+	}
+	if s, _ := d.symbol(prev); s != "" {
+		return 0
+	}
+	if s, _ := d.symbol(r); s != "" {
+		return 0
+	}
+	return d.font.kern(prev, r).Round()
+}
+
 func (d *Dot) Visible(r rune) bool {
 	switch r {
 	case '\t', '\n':
@@ -88,12 +257,63 @@ func (d *Dot) fits(r rune) int {
 	return adv
 }
 
-func (d *Dot) fitsbox(b *Box) int {
-	adv := b.Width()
-	if d.Width()+adv > d.maxw {
-		return -1
+// measure returns the sum of the advances of the runes in s
+// and the kerning between them
+func (d *Dot) measure(s []byte) int {
+	return d.originOf(s, len(s))
+}
+
+// originOf returns the distance from the start of s to where
+// the glyph at byte n is drawn
+func (d *Dot) originOf(s []byte, n int) (w int) {
+	prev := rune(-1)
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		w += d.pair(prev, r)
+		if i >= n {
+			return w
+		}
+		w += d.Advance(r)
+		prev = r
+		i += size
 	}
-	return adv
+	return w
+}
+
+// remaining returns the number of pixels left on the line
+func (d *Dot) remaining() int {
+	return d.maxw - d.Width()
+}
+
+// fit returns the number of bytes in the text box b that
+// fit on the rest of the line
+func (d *Dot) fit(b *Box) (n int) {
+	s := b.Bytes()
+	w := d.remaining()
+	prev := rune(-1)
+	for n < len(s) {
+		r, size := utf8.DecodeRune(s[n:])
+		if w -= d.pair(prev, r) + d.Advance(r); w < 0 {
+			break
+		}
+		prev = r
+		n += size
+	}
+	return n
+}
+
+// wordfit is like fit, but only breaks the box after a
+// whitespace rune. It returns zero if no whitespace fits.
+func (d *Dot) wordfit(b *Box) (n int) {
+	s := b.Bytes()[:d.fit(b)]
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		i += size
+		if unicode.IsSpace(r) {
+			n = i
+		}
+	}
+	return n
 }
 
 // Insert advances dot by the width of r, or starts a new
@@ -107,45 +327,61 @@ func (d *Dot) Insert(r rune) image.Point {
 	return d.Point
 }
 
+// InsertBox places box b at dot and advances dot past it. It
+// returns the point where the box begins. A text box that
+// doesn't fit on the rest of the line starts a new one. The
+// width of a break box depends on where it lands: a newline
+// covers the rest of the line and moves dot to the next, and
+// a tab covers the distance to its tab stop (or its elastic
+// width), clipped to the margin.
 func (d *Dot) InsertBox(b *Box) image.Point {
-	if adv := d.fitsbox(b); adv == -1 {
+	switch b.bc {
+	case '\n':
+		sp := d.Point
+		b.width = max(d.remaining(), 0)
 		d.Newline()
-	} else {
-		d.X += adv
+		return sp
+	case '\t':
+		if d.remaining() <= 0 {
+			d.Newline()
+		}
+		if w, ok := d.elastic[b]; ok {
+			b.width = min(w, d.remaining())
+		} else {
+			b.width = min(d.Advance('\t'), d.remaining())
+		}
+	default:
+		if d.Width() > 0 && b.Width() > d.remaining() {
+			d.Newline()
+		}
 	}
-	return d.Point
+	sp := d.Point
+	d.X += b.Width()
+	return sp
 }
 
-// IndexOf computes the index of the glyph containing pt
-func (dot *Dot) indexOf(box *Box, pt image.Point) (i int) {
-	//defer func() { fmt.Printf("IndexOf: pt=%v i=%d (%c)\n", pt, i, f.s[i])}()
-	pt = dot.alignY(pt)
+// indexOf returns the byte offset of the glyph in box under
+// pt.X. Dot must be positioned at the start of the box.
+func (d *Dot) indexOf(box *Box, pt image.Point) (i int) {
+	if box.IsBreak() {
+		if pt.X <= d.X+box.Width()/2 || box.bc == '\n' {
+			return 0
+		}
+		return box.Len()
+	}
 	s := box.Bytes()
-	for i = 0; i < len(s); i++ {
-		switch {
-		case dot.Y < pt.Y:
-			// nothing special
-		case dot.Y == pt.Y:
-			// same line
-			if dot.X+dot.Advance(rune(s[i]))/2 >= pt.X {
-				return i
-			}
-		case dot.Y > pt.Y:
-			// advanced too far
-			if i-1 < 0 {
-				// bug fix for crash: happened when selecting
-				// and dragging all the way to the top
-				return i
-			}
-			if s[i-1] == '\n' {
-				// a hard newline
-				return i - 1
-			} else {
-				// line wrapped
-				return i
-			}
+	x := d.X
+	prev := rune(-1)
+	for i < len(s) {
+		r, size := utf8.DecodeRune(s[i:])
+		x += d.pair(prev, r)
+		prev = r
+		adv := d.Advance(r)
+		if x+adv/2 >= pt.X {
+			return i
 		}
-		dot.Insert(rune(s[i]))
+		x += adv
+		i += size
 	}
 	return i
 }
@@ -157,7 +393,7 @@ func (d *Dot) Width() int {
 }
 
 func (d *Dot) Height() int {
-	return d.font.Height()
+	return max(d.font.Height()+d.leading, 1)
 }
 
 func nlpos(p []byte) (i int) {
@@ -1,24 +1,31 @@
 package frame
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
-	"golang.org/x/image/font"
 	"golang.org/x/image/math/fixed"
+	"hash/fnv"
 	"image"
 	"image/color"
 	"image/draw"
+	"unicode/utf8"
 )
 
+// Draw draws the frame onto its canvas. Unless force is set,
+// only the lines touched by the dirty ranges, and those that
+// changed or moved since they were last drawn, are drawn
+// again. A line that moved is copied from where it was. Draw
+// must be forced after changing the frame's font or colors.
 func (f *Frame) Draw(force bool) {
 	f.Tick.Draw()
-	if !force {
-		for _, r := range f.DirtyRange() {
-			f.RedrawRange(r.I, r.J)
-		}
-	} else {
+	if force || f.drawn == nil {
 		f.Redraw(f.selecting)
+	} else {
+		f.update()
 	}
 	f.CleanRange()
+	f.drawzoom()
 }
 
 type Drawer interface {
@@ -49,38 +56,195 @@ func (f *Frame) Resize(size image.Point) {
 	f.resize(size)
 }
 
-func (f *Frame) reallocY(dot image.Point) bool {
-	if dot.Y >= f.Bounds().Max.Y {
-		f.resize(image.Pt(f.size.X, f.size.Y*2))
-		return true
-	}
-	return false
-}
-
 func (f *Frame) resize(size image.Point) {
 	f.size = size
-	f.disp = image.NewRGBA(image.Rect(0, 0, f.size.X, f.size.Y))
+	f.disp = image.NewRGBA(f.canvas())
+	f.setwrap()
+	f.layout()
+	f.fill()
 	f.Redraw(f.selecting)
-	f.Option.Wrap = f.size.X - 2*f.Origin().X
 	return
 }
 
+// setwrap makes lines as wide as the frame, unless their
+// length is set in columns
+func (f *Frame) setwrap() {
+	if f.Columns == 0 {
+		f.Option.Wrap = f.size.X - 2*f.Origin().X
+	}
+}
+
 // Redraw redraws the entire frame. The caller should check
 // that the frame is Dirty before calling this in a tight
 // loop
 func (f *Frame) Redraw(selecting bool) {
-	draw.Draw(f.disp, f.Bounds(), f.Colors.Back, image.ZP, draw.Src)
-	f.RedrawRange(0, f.nbytes)
+	draw.Draw(f.disp, f.disp.Bounds(), f.Colors.Back, image.ZP, draw.Src)
+	f.damage = append(f.damage[:0], f.disp.Bounds())
+	f.drawn = make([]uint64, len(f.lines))
+	for n := range f.lines {
+		f.drawline(n)
+		f.drawn[n] = f.signature(n)
+	}
 }
 
+// RedrawRange redraws the lines holding the bytes [i, j), or
+// the line holding i if the range is empty
 func (f *Frame) RedrawRange(i, j int) {
-	f.boxes.Dump()
-	bi, _ := f.boxes.Find(0, 0, i)
-	bj, _ := f.boxes.Find(bi, 0, j)
-	fmt.Printf("note: i=%d j=%d bi=%d bj=%d\n", i, j, bi, bj)
-	bi = 0
-	bj = len(f.boxes.Box)
-	f.RedrawBox(bi, bj)
+	if len(f.lines) == 0 {
+		return
+	}
+	for n := f.LineOf(i); n <= f.LineOf(max(j-1, i)); n++ {
+		f.redrawLine(n)
+	}
+}
+
+// redrawLine clears line n and draws its boxes
+func (f *Frame) redrawLine(n int) {
+	draw.Draw(f.disp, f.lineRect(n), f.Colors.Back, image.ZP, draw.Src)
+	f.drawline(n)
+	f.damaged(f.lineRect(n))
+	if n < len(f.drawn) {
+		f.drawn[n] = f.signature(n)
+	}
+}
+
+// drawline draws the boxes on line n clipped to the line, so
+// glyphs reaching past its top or bottom leave the lines next
+// to it alone. Those lines needn't be redrawn with it.
+func (f *Frame) drawline(n int) {
+	disp := f.disp
+	f.disp = disp.SubImage(f.lineRect(n)).(*image.RGBA)
+	f.RedrawBox(f.lineBoxes(n))
+	f.disp = disp
+}
+
+// update redraws the lines in the dirty ranges and those that
+// don't look the way they were last drawn. A line that was
+// drawn elsewhere on the canvas is copied from there, and the
+// lines left below the last one are cleared.
+func (f *Frame) update() {
+	dirty := make(map[int]bool)
+	for _, r := range f.dirtyrange {
+		for n := f.LineOf(r.I); n <= f.LineOf(max(r.J-1, r.I)); n++ {
+			dirty[n] = true
+		}
+	}
+	was := make(map[uint64]int, len(f.drawn))
+	for n, sig := range f.drawn {
+		if _, ok := was[sig]; !ok {
+			was[sig] = n
+		}
+	}
+	drawn := make([]uint64, len(f.lines))
+	var moves [][2]int
+	var redraw []int
+	for n := range f.lines {
+		sig := f.signature(n)
+		drawn[n] = sig
+		m, ok := was[sig]
+		switch {
+		case dirty[n]:
+			redraw = append(redraw, n)
+		case n < len(f.drawn) && f.drawn[n] == sig:
+		case ok && f.lineRect(m).In(f.disp.Bounds()):
+			moves = append(moves, [2]int{m, n})
+		default:
+			redraw = append(redraw, n)
+		}
+	}
+	f.blit(moves)
+	for n := len(f.lines); n < len(f.drawn); n++ {
+		draw.Draw(f.disp, f.lineRect(n), f.Colors.Back, image.ZP, draw.Src)
+		f.damaged(f.lineRect(n))
+	}
+	f.drawn = drawn
+	for _, n := range redraw {
+		f.redrawLine(n)
+	}
+}
+
+// blit copies line m of the canvas to line n for each pair
+// {m, n} in moves. The lines are copied as they were before
+// any of them was overwritten.
+func (f *Frame) blit(moves [][2]int) {
+	if len(moves) == 0 {
+		return
+	}
+	if f.scratch == nil || f.scratch.Bounds() != f.disp.Bounds() {
+		f.scratch = image.NewRGBA(f.disp.Bounds())
+	}
+	for _, mv := range moves {
+		r := f.lineRect(mv[0])
+		draw.Draw(f.scratch, r, f.disp, r.Min, draw.Src)
+	}
+	for _, mv := range moves {
+		draw.Draw(f.disp, f.lineRect(mv[1]), f.scratch, f.lineRect(mv[0]).Min, draw.Src)
+		f.damaged(f.lineRect(mv[1]))
+	}
+}
+
+// damaged records that the rectangle r of the canvas changed.
+// A rectangle as wide as the last one recorded and touching it
+// is merged into it.
+func (f *Frame) damaged(r image.Rectangle) {
+	r = r.Intersect(f.disp.Bounds())
+	if r.Empty() {
+		return
+	}
+	if n := len(f.damage); n > 0 {
+		last := &f.damage[n-1]
+		if r.In(*last) {
+			return
+		}
+		if r.Min.X == last.Min.X && r.Max.X == last.Max.X && r.Min.Y <= last.Max.Y && r.Max.Y >= last.Min.Y {
+			*last = last.Union(r)
+			return
+		}
+	}
+	f.damage = append(f.damage, r)
+}
+
+// lineRect returns the rectangle line n covers on the canvas,
+// which spans its width
+func (f *Frame) lineRect(n int) image.Rectangle {
+	r := f.disp.Bounds()
+	h := f.FontHeight()
+	y := f.canvasOrigin().Y + n*h
+	return image.Rect(r.Min.X, y, r.Max.X, y+h)
+}
+
+// lineBoxes returns the range of boxes [bi, bj) on line n
+func (f *Frame) lineBoxes(n int) (bi, bj int) {
+	bi, bj = f.lines[n].bn, len(f.boxes.Box)
+	if n+1 < len(f.lines) {
+		bj = f.lines[n+1].bn
+	}
+	return bi, bj
+}
+
+// signature returns a hash of the boxes on line n and where
+// they lie on it. Lines with the same signature look the same.
+func (f *Frame) signature(n int) uint64 {
+	h := fnv.New64a()
+	var b [16]byte
+	bi, bj := f.lineBoxes(n)
+	for bn := bi; bn < bj; bn++ {
+		box := f.Box(bn)
+		trail := 0
+		if f.ShowSpace && !box.IsBreak() {
+			trail = f.trailing(bn)
+		}
+		binary.LittleEndian.PutUint32(b[0:], uint32(f.pos[bn].X))
+		binary.LittleEndian.PutUint32(b[4:], uint32(box.Width()))
+		binary.LittleEndian.PutUint32(b[8:], uint32(box.Len()))
+		binary.LittleEndian.PutUint32(b[12:], uint32(trail))
+		h.Write(b[:])
+		h.Write(box.Bytes())
+		if len(f.styles) > 0 {
+			f.writestyle(h, bn)
+		}
+	}
+	return h.Sum64()
 }
 
 func note(i, j int) {
@@ -88,39 +252,130 @@ func note(i, j int) {
 }
 
 func (f *Frame) RedrawBox(i, j int) {
-	dot := NewDot(f.Origin(), f.Option.Wrap, f.Font)
-	note(i, j)
-	for _, box := range f.Boxes()[i:j] {
-		i, sp := 0, dot.Point
-		w := sp.X
-		dot.InsertBox(box)
-		r := image.Rect(sp.X, sp.Y, w, sp.Y+dot.font.Height())
-		draw.Draw(f.disp, r, f.Colors.Back, image.ZP, draw.Src)
-		s := box.Bytes()
-		if i-1 >= 0 && i-1 < len(s) && s[i-1] == '\n' {
-			f.RedrawBytes(dot.Point, dot.maxw, s)
-		} else {
-			f.RedrawBytes(dot.Point, dot.maxw, s)
+	h := f.FontHeight()
+	for bn, box := range f.Boxes()[i:j] {
+		sp := f.pos[i+bn].Sub(image.Pt(f.xoff, 0))
+		r := image.Rect(sp.X, sp.Y, sp.X+box.Width(), sp.Y+h)
+		if !r.Overlaps(f.disp.Bounds()) {
+			continue
+		}
+		f.drawbox(i+bn, sp, r)
+		if f.ShowSpace {
+			f.drawspace(i+bn, r)
 		}
 	}
 }
 
-func (f *Frame) RedrawBytes(origin image.Point, width int, s []byte) {
-	fmt.Printf("RedrawBytes: origin=%v width=%v s=%q\n", origin, width, s)
-	dot := NewDot(origin, width, f.Font)
-	for s := s; len(s) != 0; {
-		i, sp := 0, dot.Point
-		w := sp.X
-		for ; i < len(s) && sp.Y == dot.Y; i++ {
-			w = dot.X
-			dot.Insert(rune(s[i]))
+// drawbox draws box bn at sp in the rectangle r, splitting it
+// where its style changes. The backgrounds are drawn before
+// the text, so glyphs overhanging a neighbouring run aren't
+// clipped by it.
+func (f *Frame) drawbox(bn int, sp image.Point, r image.Rectangle) {
+	box := f.Box(bn)
+	s := box.Bytes()
+	q := f.org + f.off[bn]
+	if st, j := f.style(q, q+len(s)); j == q+len(s) {
+		draw.Draw(f.disp, r, st.Back, image.ZP, draw.Src)
+		if !box.IsBreak() {
+			f.stringbg(f.disp, sp, st.Text, image.ZP, f.Font, s, box.Width(), st.Back, image.ZP)
 		}
-		if i-1 >= 0 && i-1 < len(s) && s[i-1] == '\n' {
-			f.drawtext(sp, dot.maxw-w, s[:i-1])
-		} else {
-			f.drawtext(sp, dot.maxw-w, s[:i])
+		return
+	}
+	type span struct {
+		a, b, x0, x1 int
+		st           Style
+	}
+	var spans []span
+	dot := f.newDot()
+	for a, b := 0, 0; a < len(s); a = b {
+		var st Style
+		st, b = f.style(q+a, q+len(s))
+		b -= q
+		x1 := box.Width()
+		if b < len(s) {
+			x1 = dot.originOf(s, b)
+		}
+		spans = append(spans, span{a, b, dot.originOf(s, a), x1, st})
+	}
+	for _, v := range spans {
+		draw.Draw(f.disp, image.Rect(r.Min.X+v.x0, r.Min.Y, r.Min.X+v.x1, r.Max.Y), v.st.Back, image.ZP, draw.Src)
+	}
+	for _, v := range spans {
+		f.stringbg(f.disp, sp.Add(image.Pt(v.x0, 0)), v.st.Text, image.ZP, f.Font, s[v.a:v.b], v.x1-v.x0, v.st.Back, image.ZP)
+	}
+}
+
+// drawspace marks the tab, newline or trailing spaces in box
+// bn, which is drawn in r
+func (f *Frame) drawspace(bn int, r image.Rectangle) {
+	box := f.Box(bn)
+	switch box.BreakChar() {
+	case '\t':
+		f.drawmark(r, '→')
+		return
+	case '\n':
+		f.drawmark(r, '¶')
+		return
+	}
+	s := box.Bytes()
+	dot := f.newDot()
+	dx := dot.advance(' ')
+	for i := f.trailing(bn); i < len(s); i++ {
+		x := r.Min.X + dot.originOf(s, i)
+		f.drawmark(image.Rect(x, r.Min.Y, x+dx, r.Max.Y), '·')
+	}
+}
+
+// trailing returns the offset in text box bn of the spaces
+// ending its line, or the length of the box if there are none
+func (f *Frame) trailing(bn int) int {
+	s := f.Box(bn).Bytes()
+	n := bn + 1
+	for ; n < len(f.boxes.Box); n++ {
+		box := f.Box(n)
+		if box.IsBreak() || len(bytes.Trim(box.Bytes(), " ")) > 0 {
+			break
 		}
-		s = s[i:]
+	}
+	switch {
+	case n < len(f.boxes.Box) && f.Box(n).BreakChar() != '\n':
+		return len(s)
+	case n == len(f.boxes.Box) && f.text != nil && f.org+f.Len() < f.text.Len():
+		// the line goes on past the bottom of the frame
+		return len(s)
+	}
+	return len(bytes.TrimRight(s, " "))
+}
+
+// spacecolor returns the color of whitespace marks
+func (f *Frame) spacecolor() image.Image {
+	if f.Colors.Space != nil {
+		return f.Colors.Space
+	}
+	return f.Colors.Text
+}
+
+// drawmark draws the glyph for c at the start of r in the
+// whitespace color, clipped to r
+func (f *Frame) drawmark(r image.Rectangle, c rune) {
+	fp := fixed.P(r.Min.X, r.Min.Y+f.baseline())
+	clip := f.disp.SubImage(r).(*image.RGBA)
+	f.Font.drawglyph(clip, fp, c, f.spacecolor(), image.ZP)
+}
+
+// drawsymbol draws the text s that stands in for a control
+// character of width w at p. Padded text is boxed.
+func (f *Frame) drawsymbol(dst draw.Image, p image.Point, s string, pad, w int) {
+	h := f.FontHeight()
+	d := f.newDot()
+	x := p.X + pad
+	for _, c := range s {
+		fp := fixed.P(x, p.Y+f.baseline())
+		f.Font.drawglyph(dst, fp, c, f.spacecolor(), image.ZP)
+		x += d.advance(c)
+	}
+	if pad > 0 {
+		drawBorder(dst, image.Rect(p.X, p.Y+1, p.X+w, p.Y+h-1), f.spacecolor(), image.ZP, 1)
 	}
 }
 
@@ -131,35 +386,34 @@ func (f *Frame) drawtext(pt image.Point, width int, s []byte) (dx int, i int) {
 	return f.stringbg(f.disp, pt, f.Colors.Text, image.ZP, f.Font, s, width, f.Colors.Text, image.ZP)
 }
 
+// measure returns the width of s as laid out by a dot, so
+// box widths agree with hit testing and drawing
 func (f *Frame) measure(s []byte) int {
-	return int(font.MeasureBytes(f.Font, s) >> 6)
+	return f.newDot().measure(s)
 }
 
-func (f *Frame) stringbg(dst draw.Image, p image.Point, src image.Image, sp image.Point, font font.Face, s []byte, width int, bg image.Image, bgp image.Point) (int, int) {
-	h := f.Font.Height()
-	h = int(float64(h) - float64(h)/float64(5))
+func (f *Frame) stringbg(dst draw.Image, p image.Point, src image.Image, sp image.Point, ft *Font, s []byte, width int, bg image.Image, bgp image.Point) (int, int) {
+	h := f.baseline()
 	i := 0
-	if f.dot == nil {
-		f.dot = NewDot(f.Origin(), f.Option.Wrap, f.Font)
-	}
-	for _, v := range s {
-		fp := fixed.P(p.X, p.Y)
+	d := f.newDot()
+	prev := rune(-1)
+	for i < len(s) {
+		v, size := utf8.DecodeRune(s[i:])
+		kern := d.pair(prev, v)
+		p.X += kern
+		width -= kern
+		fp := fixed.P(p.X, p.Y+h)
 
-		if f.dot.Visible(rune(v)) {
-			dr, mask, maskp, _, ok := font.Glyph(fp, rune(v))
-			if !ok {
-				break
-			}
-			dr.Min.Y += h
-			dr.Max.Y += h
-			draw.DrawMask(dst, dr, src, sp, mask, maskp, draw.Over)
+		if sym, pad := d.symbol(v); sym != "" {
+			f.drawsymbol(dst, p, sym, pad, d.Advance(v))
+		} else if d.Visible(v) {
+			ft.drawglyph(dst, fp, v, src, sp)
 		}
 
-		dx := f.dot.Advance(rune(v))
-		//dx := int((advance + f.Font.Kern(f.last, rune(v))) >> 6)
+		dx := d.Advance(v)
 		p.X += dx
-		i++
-		f.last = rune(v)
+		i += size
+		prev = v
 		width -= dx
 		if width < 1 {
 			break
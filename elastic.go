@@ -0,0 +1,163 @@
+package frame
+
+// cell is the text before a tab on a line and the tab's box
+type cell struct {
+	width int
+	tab   *Box
+}
+
+// retab recomputes the width of the tabs in the block of lines
+// around the bytes [i, j) when elastic tabstops are enabled. It
+// returns the offset where the frame's layout must start over,
+// which is i unless the block begins before it.
+//
+// A block is a run of contiguous lines containing tabs. The
+// text before the nth tab on every line of the run forms a
+// column, and the tabs ending the column are sized so the next
+// column begins at the same place on each of those lines.
+func (f *Frame) retab(i, j int) int {
+	if !f.Elastic {
+		return i
+	}
+	if f.tabs == nil {
+		f.tabs = make(map[*Box]int)
+	}
+	boxes := f.Boxes()
+
+	// widen [i, j) to whole lines, then take in the lines with
+	// tabs on either side; an edit can join or part the blocks
+	// next to it
+	b0, q0 := f.boxnear(i)
+	b0, q0 = linestart(boxes, b0, q0)
+	for b0 > 0 {
+		p, q := linestart(boxes, b0-1, q0-boxes[b0-1].Len())
+		if !hastab(boxes[p:b0]) {
+			break
+		}
+		b0, q0 = p, q
+	}
+	b1, q1 := b0, q0
+	for b1 < len(boxes) && q1+boxes[b1].Len() <= j {
+		q1 += boxes[b1].Len()
+		b1++
+	}
+	b1 = lineend(boxes, b1)
+	for b1 < len(boxes) {
+		n := lineend(boxes, b1)
+		if !hastab(boxes[b1:n]) {
+			break
+		}
+		b1 = n
+	}
+
+	// split the block into lines of cells
+	var (
+		lines [][]cell
+		cur   []cell
+		w     int
+	)
+	for _, box := range boxes[b0:b1] {
+		switch box.BreakChar() {
+		case '\t':
+			cur = append(cur, cell{w, box})
+			w = 0
+		case '\n':
+			lines = append(lines, cur)
+			cur, w = nil, 0
+		default:
+			w += box.Width()
+		}
+	}
+	lines = append(lines, cur)
+	f.sizecolumns(lines)
+	return min(i, q0)
+}
+
+// untab forgets the widths of the tabs among the bytes [i, j),
+// which are about to be deleted
+func (f *Frame) untab(i, j int) {
+	if f.tabs == nil {
+		return
+	}
+	bn, q := f.boxnear(i)
+	for ; bn < len(f.boxes.Box) && q < j; bn++ {
+		delete(f.tabs, f.boxes.Box[bn])
+		q += f.boxes.Box[bn].Len()
+	}
+}
+
+// boxnear returns the number of the box holding offset q, or
+// the number of boxes if q is at the end, and the offset where
+// the box begins. Only the layout of the lines before the one
+// preceding q is trusted, as with relayout.
+func (f *Frame) boxnear(q int) (bn, q0 int) {
+	if n := f.LineOf(q) - 1; n >= 0 && n < len(f.lines) && f.lines[n].bn <= len(f.boxes.Box) {
+		bn, q0 = f.lines[n].bn, f.lines[n].q
+	}
+	for ; bn < len(f.boxes.Box) && q0+f.boxes.Box[bn].Len() <= q; bn++ {
+		q0 += f.boxes.Box[bn].Len()
+	}
+	return bn, q0
+}
+
+// linestart returns the number and offset of the first box on
+// the line holding box bn, which begins at offset q
+func linestart(boxes []*Box, bn, q int) (int, int) {
+	for bn > 0 && boxes[bn-1].BreakChar() != '\n' {
+		bn--
+		q -= boxes[bn].Len()
+	}
+	return bn, q
+}
+
+// lineend returns the number of the box following the newline
+// that ends the line holding box bn
+func lineend(boxes []*Box, bn int) int {
+	for bn < len(boxes) {
+		bn++
+		if boxes[bn-1].BreakChar() == '\n' {
+			break
+		}
+	}
+	return bn
+}
+
+// hastab returns true if one of boxes is a tab
+func hastab(boxes []*Box) bool {
+	for _, box := range boxes {
+		if box.BreakChar() == '\t' {
+			return true
+		}
+	}
+	return false
+}
+
+// sizecolumns assigns the width of every tab in lines. A
+// column is as wide as the widest text in it plus two spaces,
+// but never narrower than a tab stop.
+func (f *Frame) sizecolumns(lines [][]cell) {
+	pad := 2 * f.newDot().advance(' ')
+	tab := f.tabstop()
+	for c := 0; ; c++ {
+		found := false
+		for n := 0; n < len(lines); {
+			if len(lines[n]) <= c {
+				n++
+				continue
+			}
+			found = true
+			m, w := n, 0
+			for ; m < len(lines) && len(lines[m]) > c; m++ {
+				w = max(w, lines[m][c].width+pad)
+			}
+			w = max(w, tab)
+			for ; n < m; n++ {
+				cl := lines[n][c]
+				f.tabs[cl.tab] = w - cl.width
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
@@ -5,9 +5,9 @@ import "image"
 import "image/color"
 
 func (f *Frame) ckindex(i int) int {
-	nb := f.nbytes
-	if i >= nb {
-		i = nb - 1
+	nb := f.Len()
+	if i > nb {
+		i = nb
 	}
 	if i < 0 {
 		i = 0
@@ -18,7 +18,3 @@ func (f *Frame) ckindex(i int) int {
 func (f *Frame) flushcache() {
 	draw.Draw(f.cached, f.cached.Bounds(), &image.Uniform{color.RGBA{0, 0, 0, 0}}, image.ZP, draw.Src)
 }
-
-func (f *Frame) grow(n int) {
-	f.s = append(f.s, make([]byte, n)...)
-}
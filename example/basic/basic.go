@@ -202,9 +202,12 @@ func main() {
 			case paint.Event:
 				if fr.Dirty() || true{
 					 fr.Draw(false)
-					draw.Draw(buf.RGBA(), buf.Bounds(), fr.RGBA(), image.ZP, draw.Src)
-					tx.Upload(image.ZP, buf, buf.Bounds())
-					win.Copy(buf.Bounds().Min, tx, tx.Bounds(), screen.Over, nil)
+					for _, r := range fr.Damage() {
+						draw.Draw(buf.RGBA(), r, fr.RGBA(), r.Min, draw.Src)
+						tx.Upload(r.Min, buf, r)
+						win.Copy(r.Min, tx, r, screen.Over, nil)
+					}
+					fr.CleanDamage()
 				} else if !focused || resized{
 					fr.Draw(true)
 				//draw.Draw(buf.RGBA(), buf.Bounds(), fr.RGBA(), image.ZP, draw.Src)
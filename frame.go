@@ -4,21 +4,27 @@ import (
 	"github.com/golang/freetype/truetype"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
 	"golang.org/x/mobile/event/key"
 	"golang.org/x/mobile/event/mouse"
 	"image"
 	"image/color"
 	"image/draw"
 	//"fmt"
+	"errors"
 	"time"
 )
 
+var ErrBadOffset = errors.New("frame: bad offset")
+
 var (
 	AcmeColors = &Colors{
 		Back:  image.NewUniform(color.RGBA{0, 0, 0, 0}),
 		Text:  image.NewUniform(color.RGBA{255, 0, 0, 255}),
 		HText: image.NewUniform(color.RGBA{0, 0, 0, 255}),
 		HBack: image.NewUniform(color.RGBA{255, 0, 0, 128}),
+		Space: image.NewUniform(color.RGBA{128, 128, 128, 255}),
 	}
 	DefaultColors  = defaultColors
 	DarkGrayColors = &Colors{
@@ -26,27 +32,28 @@ var (
 		Text:  image.NewUniform(color.RGBA{0, 128 + 64, 128 + 64, 255}),
 		HText: image.NewUniform(color.RGBA{0, 0, 0, 255}),
 		HBack: image.NewUniform(color.RGBA{0, 128, 128, 64}),
+		Space: image.NewUniform(color.RGBA{0, 96, 96, 255}),
 	}
 	GrayColors = &Colors{
 		Back:  image.NewUniform(color.RGBA{48, 48, 48, 0}),
 		Text:  image.NewUniform(color.RGBA{99, 99, 99, 255}),
 		HText: image.NewUniform(color.RGBA{0, 0, 0, 255}),
 		HBack: image.NewUniform(color.RGBA{0, 128, 128, 64}),
+		Space: image.NewUniform(color.RGBA{66, 66, 66, 255}),
 	}
 	defaultColors = &Colors{
 		Back:  image.NewUniform(color.RGBA{33, 33, 33, 0}),
 		Text:  image.NewUniform(color.RGBA{0, 255, 255, 255}),
 		HText: image.NewUniform(color.RGBA{0, 0, 0, 255}),
 		HBack: image.NewUniform(color.RGBA{33, 255, 255, 0}),
+		Space: image.NewUniform(color.RGBA{0, 128, 128, 255}),
 	}
 	defaultOption = &Option{
 		Font:   NewFont(parseDefaultFont(12)),
-		Wrap:   80,
 		Colors: *defaultColors,
 	}
 	largeScale = &Option{
 		Font:   NewFont(parseDefaultFont(24)),
-		Wrap:   80,
 		Colors: *defaultColors,
 	}
 )
@@ -59,9 +66,7 @@ type Frame struct {
 	Option
 	Tick *Tick
 
-	s          []byte
 	width      int
-	nbytes     int
 	dirty      bool
 	dirtyrange []Range
 	selecting  bool
@@ -70,7 +75,6 @@ type Frame struct {
 	Cache Cache
 	// cache for the transformation
 	cached draw.Image
-	last   rune
 
 	lastmouse  mouse.Event
 	mousecache image.Point
@@ -79,6 +83,47 @@ type Frame struct {
 
 	boxes *Boxes
 	dot   *Dot
+
+	// text is the document the frame is a window onto and
+	// org is the offset in text of the frame's first byte
+	text  Text
+	org   int
+	full  bool
+	lines []line
+
+	// pos and off cache the point and offset where each box
+	// begins, and end is the point following the last box
+	pos []image.Point
+	off []int
+	end image.Point
+
+	hist journal
+
+	// tabs holds the width of each tab sized by elastic
+	// tabstops
+	tabs map[*Box]int
+
+	// xoff is the number of pixels on the canvas the text is
+	// scrolled left
+	xoff int
+
+	// zoom transforms the canvas onto view, which is what the
+	// frame shows while it's zoomed
+	zoom f64.Aff3
+	view *image.RGBA
+
+	// drawn holds the signature of each line as it was last
+	// drawn, and scratch holds the lines being moved while the
+	// canvas is updated
+	drawn   []uint64
+	scratch *image.RGBA
+
+	// damage holds the rectangles of the frame's image that
+	// changed since the damage was last cleaned
+	damage []image.Rectangle
+
+	// styles holds the style runs set on the frame's text
+	styles runs
 }
 
 func (f *Frame) Boxes() []*Box {
@@ -88,24 +133,104 @@ func (f *Frame) Boxes() []*Box {
 type Colors struct {
 	Text, Back   image.Image
 	HText, HBack image.Image
+
+	// Space is the color of the marks drawn for whitespace
+	// and control characters. If nil, Text is used.
+	Space image.Image
 }
 
+// WrapMode is the way a frame breaks lines that don't fit
+type WrapMode int
+
+const (
+	// WrapChar breaks a line after the last glyph that fits
+	WrapChar WrapMode = iota
+
+	// WrapWord breaks a line after the last whitespace that
+	// fits. A word wider than the line is broken like WrapChar.
+	WrapWord
+
+	// WrapNone never breaks a line
+	WrapNone
+)
+
+// ControlMode is the way a frame draws control characters
+type ControlMode int
+
+const (
+	// ControlGlyph draws whatever glyph the font has
+	ControlGlyph ControlMode = iota
+
+	// ControlCaret draws ^X for the ASCII control characters
+	// and a hex box for the rest
+	ControlCaret
+
+	// ControlHex draws the character's code in hex in a box
+	ControlHex
+)
+
 type Option struct {
 	// Font is the font face for the frame
 	*Font
 
-	// Number of glyphs drawn on one line before wrapping
+	// Wrap is the width of a line in pixels. New and Resize
+	// set it to the width of the frame unless Columns is set.
 	Wrap int
 
+	// Columns is the number of columns drawn on a line before
+	// wrapping, measured with the font's ColumnWidth. If it's
+	// set, Wrap is ignored and lines keep the same length when
+	// the frame is resized.
+	Columns int
+
+	// WrapMode selects where lines longer than Wrap are
+	// broken. The default is WrapChar.
+	WrapMode WrapMode
+
+	// Tabstop is the distance between tab stops in spaces. Tab
+	// stops are measured from the left edge of the frame's text,
+	// so tabs line up in columns. The default is 4.
+	Tabstop int
+
+	// TabstopPx is the distance between tab stops in pixels. It
+	// overrides Tabstop if set.
+	TabstopPx int
+
+	// Elastic enables elastic tabstops. The tabs on a run of
+	// contiguous lines are sized so that the text between them
+	// lines up in columns, however wide the text is.
+	Elastic bool
+
 	// Multiplicative scale factor for X and Y coordinates
-	// (1, 1) means no scale.
-	//Scale image.Point
+	// (1, 1) means no scale. The frame draws on a canvas
+	// scaled by this factor, while the coordinates passed to
+	// and returned by its methods remain logical. The font is
+	// used at the canvas's resolution, so a frame scaled by
+	// (2, 2) wants a face twice the size for crisp text.
+	Scale image.Point
+
+	// Leading is the number of pixels added between lines.
+	// It can be negative to set lines closer together.
+	Leading int
+
+	// ShowSpace marks tabs, newlines and the spaces at the end
+	// of a line in Colors.Space
+	ShowSpace bool
+
+	// ShowControl selects how control characters are drawn.
+	// Those not drawn from the font are drawn in Colors.Space.
+	ShowControl ControlMode
+
+	// Kerning adjusts the space between pairs of glyphs on a
+	// line by the font's kerning table
+	Kerning bool
 
 	// Colors define the text and background colors for the rame
 	// Text: glyph color
 	// Back: background color
 	// HText: highlighted glyph color
 	// HBack: highlighted background color
+	// Space: whitespace and control character color
 	Colors Colors
 
 	fontheight int
@@ -123,7 +248,6 @@ func New(origin, size image.Point, events Sender, opt *Option) *Frame {
 		size:   size,
 		origin: origin,
 		Option: *opt,
-		s:      make([]byte, 64),
 	}
 	menu := &Menu{
 		drawer: f,
@@ -156,11 +280,13 @@ func New(origin, size image.Point, events Sender, opt *Option) *Frame {
 	}
 	menu = menu
 	f.Menu = NewMenuFS(`C:\menu\`, f, events)
-	f.disp = image.NewRGBA(f.Bounds())
+	f.disp = image.NewRGBA(f.canvas())
 	f.cached = image.NewRGBA(image.Rectangle{image.ZP, image.Pt(f.FontHeight(), f.FontHeight())})
 	f.flushcache()
 	f.Mouse = NewMouse(time.Second/3, events, f)
 	f.boxes = NewBoxes(f.measure)
+	f.setwrap()
+	f.layout()
 	return f
 }
 
@@ -178,52 +304,79 @@ func (f *Frame) CleanRange() {
 	f.dirtyrange = nil
 }
 
+// Damage returns the rectangles of the image returned by RGBA
+// that changed since CleanDamage was last called. Lines are
+// drawn clipped to themselves, so the rectangles hold every
+// pixel drawn. After drawing the frame, a host only needs to
+// copy these to the screen.
+func (f *Frame) Damage() []image.Rectangle {
+	return f.damage
+}
+
+// CleanDamage forgets the damaged rectangles
+func (f *Frame) CleanDamage() {
+	f.damage = nil
+}
+
 // Insert inserts s starting from index i in the
-// the frame buffer.
+// the frame buffer. An index past either end of the frame
+// is moved to that end. If the frame has text attached, s is
+// inserted into the text as well and the lines pushed past
+// the bottom of the frame are dropped.
 func (f *Frame) Insert(s []byte, i int) (err error) {
-	/*
-		if i >= len(f.s) {
-			i = len(f.s) - 1
-		}
-		if i < 0 {
-			i = 0
-		}
-		if s == nil {
-			return nil
+	i = f.ckindex(i)
+	if f.text != nil {
+		if _, err = f.text.InsertAt(s, int64(f.org+i)); err != nil {
+			return err
 		}
-		f.grow(len(s) + 1)
-		f.nbytes += len(s)
-		copy(f.s[i+len(s):], f.s[i:])
-		copy(f.s[i:], s)
-	*/
+	}
+	f.record(true, i, s)
+	f.styles.insert(f.org+i, len(s))
 	f.boxes.Insert(s, i)
+	f.relayout(f.retab(i, i+len(s)))
 	f.MarkRange(i, i+len(s))
 	f.dirty = true
 	return nil
 }
 
-// Delete erases the range [i:j] in the framebuffer
-// TODO: fix i == j
+// Delete erases the range [i:j) in the framebuffer
 func (f *Frame) Delete(i, j int) (err error) {
 	if i > j {
 		i, j = j, i
 	}
-	if i < 0 {
-		i = 0
-	}
-	if j >= len(f.s) {
-		j = len(f.s) - 1
+	i, j = f.ckindex(i), f.ckindex(j)
+	if i == j {
+		return nil
 	}
-	copy(f.s[i:], f.s[j:])
-	f.nbytes -= j - i
-	if f.nbytes < 0 {
-		f.nbytes = 0
+	f.record(false, i, f.Slice(i, j))
+	if f.text != nil {
+		f.text.DeleteRange(f.org+i, f.org+j)
 	}
-	f.MarkRange(i, f.nbytes)
+	f.styles.delete(f.org+i, f.org+j)
+	f.untab(i, j)
+	f.boxes.DeleteRange(i, j)
+	f.relayout(f.retab(i, i))
+	f.fill()
+	f.MarkRange(i, i)
 	f.dirty = true
 	return nil
 }
 
+// Len returns the number of bytes in the frame
+func (f *Frame) Len() int {
+	return f.boxes.Len()
+}
+
+// Slice returns a copy of the bytes in the range [i:j)
+func (f *Frame) Slice(i, j int) []byte {
+	return f.boxes.Slice(f.ckindex(i), f.ckindex(j))
+}
+
+// ReadAt implements io.ReaderAt over the frame's contents
+func (f *Frame) ReadAt(p []byte, off int64) (n int, err error) {
+	return f.boxes.ReadAt(p, off)
+}
+
 func (f *Frame) Mark() {
 	f.dirty = true
 }
@@ -242,17 +395,25 @@ func (f *Frame) Handle(e interface{}) {
 		if e.Direction != key.DirPress && e.Direction != key.DirNone {
 			break
 		}
+		switch {
+		case e.Modifiers == key.ModControl && e.Code == key.CodeZ:
+			f.Undo()
+			return
+		case e.Modifiers == key.ModControl && e.Code == key.CodeY:
+			f.Redo()
+			return
+		}
 		switch e.Code {
 		case key.CodeRightArrow:
 			if e.Modifiers != key.ModShift {
-				t.P0++
+				t.P0 = f.boxes.NextRune(t.P0)
 			}
-			t.P1++
+			t.P1 = f.boxes.NextRune(t.P1)
 		case key.CodeLeftArrow:
 			if e.Modifiers != key.ModShift {
-				t.P0--
+				t.P0 = f.boxes.PrevRune(t.P0)
 			}
-			t.P1--
+			t.P1 = f.boxes.PrevRune(t.P1)
 		case key.CodeDeleteBackspace:
 			t.Delete()
 		case key.CodeReturnEnter:
@@ -265,24 +426,42 @@ func (f *Frame) Handle(e interface{}) {
 			}
 		}
 	case mouse.Event:
+		s := f.scale()
+		e.X /= float32(s.X)
+		e.Y /= float32(s.Y)
+		if e.Modifiers == key.ModControl && (e.Button == mouse.ButtonWheelUp || e.Button == mouse.ButtonWheelDown) {
+			z := 1.25
+			if e.Button == mouse.ButtonWheelDown {
+				z = 1 / z
+			}
+			f.ZoomBy(z, image.Pt(int(e.X), int(e.Y)))
+			return
+		}
+		e.X, e.Y = f.unzoom(e.X, e.Y)
 		f.Mouse.Process(e)
 		return
 	}
 }
 
 func (f *Frame) Image() draw.Image {
-	return f.disp
+	return f.RGBA()
 }
 
+// Bytes returns a copy of the frame's contents
 func (f *Frame) Bytes() []byte {
-	return f.s
+	return f.Slice(0, f.Len())
 }
 
 func (f *Frame) Bounds() (r image.Rectangle) {
 	return image.Rectangle{image.ZP, f.size}
 }
 
+// RGBA returns the image the frame is drawn on, after it's
+// zoomed
 func (f *Frame) RGBA() *image.RGBA {
+	if f.zoomed() && f.view != nil {
+		return f.view
+	}
 	return f.disp
 }
 
@@ -326,8 +505,22 @@ func (c *Cache) Set(bounds image.Rectangle, i int, b byte) {
 	c.valid = false
 }
 
+// FontHeight returns the height of a line of text on the
+// canvas, which is the height of the font plus the leading
 func (o Option) FontHeight() int {
-	return o.Font.Height()
+	return max(o.Font.Height()+o.Leading*o.scale().Y, 1)
+}
+
+// Height returns the logical height of a line of text
+func (o Option) Height() int {
+	s := o.scale().Y
+	return (o.FontHeight() + s - 1) / s
+}
+
+// baseline returns the distance from the top of a line to the
+// baseline of its glyphs on the canvas
+func (o Option) baseline() int {
+	return o.Leading*o.scale().Y/2 + o.Font.baseline()
 }
 
 func ParseDefaultFont(size float64) font.Face {
@@ -339,7 +532,41 @@ func parseDefaultFont(size float64) font.Face {
 	if err != nil {
 		panic(err)
 	}
-	return truetype.NewFace(f, &truetype.Options{
+	return NewTrueTypeFace(f, &truetype.Options{
 		Size: size,
 	})
 }
+
+// NewTrueTypeFace returns a face for f. Unlike the face from
+// truetype.NewFace, it reports the glyphs f doesn't have as
+// missing instead of drawing a placeholder, so they can be
+// taken from a Font's fallback faces.
+func NewTrueTypeFace(f *truetype.Font, opt *truetype.Options) font.Face {
+	return &ttface{Face: truetype.NewFace(f, opt), f: f}
+}
+
+type ttface struct {
+	font.Face
+	f *truetype.Font
+}
+
+func (t *ttface) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	if t.f.Index(r) == 0 {
+		return
+	}
+	return t.Face.Glyph(dot, r)
+}
+
+func (t *ttface) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	if t.f.Index(r) == 0 {
+		return
+	}
+	return t.Face.GlyphBounds(r)
+}
+
+func (t *ttface) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	if t.f.Index(r) == 0 {
+		return
+	}
+	return t.Face.GlyphAdvance(r)
+}
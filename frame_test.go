@@ -1,7 +1,23 @@
 package frame
 
 import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math/rand"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/math/fixed"
 )
 
 func newBoxesFixed() *Boxes {
@@ -15,10 +31,10 @@ func TestDup(t *testing.T) {
 	want := "mink"
 	b.Insert([]byte(want), 0)
 	N := 2
-	for i := 1; i < N+1; i++ {
-		b.Dup(1)
+	for i := 0; i < N; i++ {
+		b.Dup(0)
 	}
-	for i := 1; i < N+1; i++ {
+	for i := 0; i < N+1; i++ {
 		if have := string(b.Box[i].data); have != want {
 			t.Logf("box #%d: want %q have %q\n", i, want, have)
 			t.FailNow()
@@ -34,8 +50,8 @@ func TestTruncate(t *testing.T) {
 		}
 	}
 	b.Insert([]byte("supermink"), 0)
-	b.Truncate(1, 1)
-	ck(1, "s")
+	b.Truncate(0, 1)
+	ck(0, "s")
 }
 
 func TestChop(t *testing.T) {
@@ -47,8 +63,8 @@ func TestChop(t *testing.T) {
 		}
 	}
 	b.Insert([]byte("supermink"), 0)
-	b.Chop(1, 1)
-	ck(1, "upermink")
+	b.Chop(0, 1)
+	ck(0, "upermink")
 }
 
 func TestSplit(t *testing.T) {
@@ -60,24 +76,24 @@ func TestSplit(t *testing.T) {
 		}
 	}
 	b.Insert([]byte("supermink"), 0)
-	b.Split(1, len("super"))
-	ck(1, "super")
+	b.Split(0, len("super"))
+	ck(0, "super")
+	ck(1, "mink")
+	b.Split(0, len("sup"))
+	ck(0, "sup")
+	ck(1, "er")
 	ck(2, "mink")
-	b.Split(1, len("sup"))
-	ck(1, "sup")
-	ck(2, "er")
-	ck(3, "mink")
-	b.Split(3, len("mi"))
-	ck(1, "sup")
-	ck(2, "er")
-	ck(3, "mi")
-	ck(4, "nk")
+	b.Split(2, len("mi"))
+	ck(0, "sup")
+	ck(1, "er")
+	ck(2, "mi")
+	ck(3, "nk")
 }
 
 func TestFind(t *testing.T) {
 	b := newBoxesFixed()
 	b.Insert([]byte("mink"), 0)
-	want := 1
+	want := 0
 	have, _ := b.Find(0, 0, 0)
 	if have != want {
 		t.Logf("want %v have %v\n", want, have)
@@ -94,14 +110,14 @@ func TestMerge(t *testing.T) {
 		}
 	}
 	b.Insert([]byte("super"), 0)
-	ck(1, "super")
+	ck(0, "super")
 	b.Add(1)
-	b.Box[2].data = []byte("mink")
-	b.Box[2].width = 4 * 4
-	ck(2, "mink")
+	b.Box[1].data = []byte("mink")
+	b.Box[1].width = 4 * 4
+	ck(1, "mink")
 
-	b.Merge(1)
-	ck(1, "supermink")
+	b.Merge(0)
+	ck(0, "supermink")
 }
 
 func TestBoxInsert00(t *testing.T) {
@@ -113,7 +129,7 @@ func TestBoxInsert00(t *testing.T) {
 		}
 	}
 	b.Insert([]byte("mink"), 0)
-	ck(1, "mink")
+	ck(0, "mink")
 }
 func TestBoxInsert01(t *testing.T) {
 	b := newBoxesFixed()
@@ -124,10 +140,10 @@ func TestBoxInsert01(t *testing.T) {
 		}
 	}
 	b.Insert([]byte("mink"), 0)
-	ck(1, "mink")
+	ck(0, "mink")
 	b.Insert([]byte("mink"), 1)
-	ck(1, "mmink")
-	ck(2, "ink")
+	ck(0, "mmink")
+	ck(1, "ink")
 }
 
 func TestBoxWidth(t *testing.T) {
@@ -139,7 +155,1610 @@ func TestBoxWidth(t *testing.T) {
 		}
 	}
 	b.Insert([]byte("mink"), 0)
-	ck(1, 4*4)
+	ck(0, 4*4)
 	b.Insert([]byte("nk or"), 2)
-	ck(1, len("mink or")*4)
+	ck(0, len("mink or")*4)
+}
+
+func TestBoxNrune(t *testing.T) {
+	b := newBoxesFixed()
+	b.Insert([]byte("héllo, 世界"), 0)
+	if have, want := b.Box[0].Nrune(), 9; have != want {
+		t.Logf("nrune: want %d have %d\n", want, have)
+		t.FailNow()
+	}
+	b.Split(0, len("héllo, 世"))
+	if have, want := b.Box[0].Nrune(), 8; have != want {
+		t.Logf("box #0: nrune: want %d have %d\n", want, have)
+		t.FailNow()
+	}
+	if have, want := b.Box[1].Nrune(), 1; have != want {
+		t.Logf("box #1: nrune: want %d have %d\n", want, have)
+		t.FailNow()
+	}
+}
+
+func TestBoxRuneStep(t *testing.T) {
+	b := newBoxesFixed()
+	b.Insert([]byte("a世b"), 0)
+	b.Split(0, len("a世"))
+	for _, tc := range []struct {
+		off, next, prev int
+	}{
+		{0, 1, 0},
+		{1, 4, 0},
+		{4, 5, 1},
+		{5, 5, 4},
+	} {
+		if have := b.NextRune(tc.off); have != tc.next {
+			t.Logf("NextRune(%d): want %d have %d\n", tc.off, tc.next, have)
+			t.Fail()
+		}
+		if have := b.PrevRune(tc.off); have != tc.prev {
+			t.Logf("PrevRune(%d): want %d have %d\n", tc.off, tc.prev, have)
+			t.Fail()
+		}
+	}
+}
+
+func newFrame() *Frame {
+	f := New(image.ZP, image.Pt(640, 480), nil, nil)
+	f.Tick = NewTick(f)
+	return f
+}
+
+func TestFrameStore(t *testing.T) {
+	f := newFrame()
+	ck := func(want string) {
+		if have := string(f.Bytes()); have != want {
+			t.Logf("frame: want %q have %q\n", want, have)
+			t.FailNow()
+		}
+		if have := f.Len(); have != len(want) {
+			t.Logf("frame: len: want %d have %d\n", len(want), have)
+			t.FailNow()
+		}
+	}
+	f.Insert([]byte("super"), 0)
+	f.Insert([]byte("mink"), 5)
+	ck("supermink")
+	f.Insert([]byte("duper"), 5)
+	ck("superduper" + "mink")
+	f.Delete(0, 5)
+	ck("dupermink")
+	f.Delete(5, 9)
+	ck("duper")
+	f.Delete(2, 2)
+	ck("duper")
+}
+
+func TestTickRead(t *testing.T) {
+	f := newFrame()
+	tick := f.Tick
+	tick.Write([]byte("the quick brown fox"))
+	tick.P0, tick.P1 = 4, 9
+	if have, want := tick.String(), "quick"; have != want {
+		t.Logf("tick: want %q have %q\n", want, have)
+		t.FailNow()
+	}
+	p := make([]byte, 32)
+	n, _ := tick.Read(p)
+	if have, want := string(p[:n]), "quick"; have != want {
+		t.Logf("tick read: want %q have %q\n", want, have)
+		t.FailNow()
+	}
+	tick.Delete()
+	if have, want := string(f.Bytes()), "the  brown fox"; have != want {
+		t.Logf("frame: want %q have %q\n", want, have)
+		t.FailNow()
+	}
+}
+
+func TestBoxDelete(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ins  []string
+		i, j int
+		want string
+	}{
+		{"empty", []string{"mink"}, 2, 2, "mink"},
+		{"all", []string{"mink"}, 0, 4, ""},
+		{"head", []string{"supermink"}, 0, 5, "mink"},
+		{"tail", []string{"supermink"}, 5, 9, "super"},
+		{"middle", []string{"supermink"}, 2, 7, "sunk"},
+		{"span", []string{"super", "duper", "mink"}, 3, 12, "supnk"},
+		{"boundary", []string{"super", "mink"}, 5, 9, "super"},
+		{"past", []string{"mink"}, 2, 99, "mi"},
+	} {
+		b := newBoxesFixed()
+		off := 0
+		for _, s := range tc.ins {
+			b.Insert([]byte(s), off)
+			off += len(s)
+		}
+		b.DeleteRange(tc.i, tc.j)
+		if have := string(b.Slice(0, b.Len())); have != tc.want {
+			t.Logf("%s: want %q have %q\n", tc.name, tc.want, have)
+			t.Fail()
+		}
+		for bn, bp := range b.Box {
+			if have, want := bp.Width(), bp.Len()*4; have != want {
+				t.Logf("%s: box #%d: width: want %d have %d\n", tc.name, bn, want, have)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestBoxBreak(t *testing.T) {
+	b := newBoxesFixed()
+	b.Insert([]byte("ab\ncd\tef"), 0)
+	for i, want := range []struct {
+		s  string
+		bc rune
+	}{
+		{"ab", 0}, {"\n", '\n'}, {"cd", 0}, {"\t", '\t'}, {"ef", 0},
+	} {
+		bp := b.Box[i]
+		if have := string(bp.Bytes()); have != want.s {
+			t.Logf("box #%d: want %q have %q\n", i, want.s, have)
+			t.FailNow()
+		}
+		if have := bp.BreakChar(); have != want.bc {
+			t.Logf("box #%d: break: want %q have %q\n", i, want.bc, have)
+			t.FailNow()
+		}
+	}
+	b.DeleteRange(2, 3)
+	if have, want := string(b.Slice(0, b.Len())), "abcd\tef"; have != want {
+		t.Logf("want %q have %q\n", want, have)
+		t.FailNow()
+	}
+}
+
+func TestFrameLayout(t *testing.T) {
+	f := newFrame()
+	f.Insert([]byte("ab\ncd\tef\n\nmink"), 0)
+	h := f.FontHeight()
+	o := f.Origin()
+	for _, tc := range []struct {
+		i  int
+		pt image.Point
+	}{
+		{0, o},
+		{2, o.Add(image.Pt(f.measure([]byte("ab")), 0))},
+		{3, o.Add(image.Pt(0, h))},
+		{5, o.Add(image.Pt(f.measure([]byte("cd")), h))},
+		{10, o.Add(image.Pt(0, 3*h))},
+		{14, o.Add(image.Pt(f.measure([]byte("mink")), 3*h))},
+	} {
+		if have := f.PointOf(tc.i); have != tc.pt {
+			t.Logf("PointOf(%d): want %v have %v\n", tc.i, tc.pt, have)
+			t.Fail()
+		}
+	}
+	for i := 0; i <= f.Len(); i++ {
+		if have, _ := f.IndexOf(f.PointOf(i)); have != i {
+			t.Logf("IndexOf(PointOf(%d)): have %d\n", i, have)
+			t.Fail()
+		}
+	}
+	if have, _ := f.IndexOf(o.Add(image.Pt(f.Option.Wrap-1, 0))); have != 2 {
+		t.Logf("IndexOf past end of line: want 2 have %d\n", have)
+		t.Fail()
+	}
+}
+
+func TestFrameWrap(t *testing.T) {
+	f := newFrame()
+	f.Option.Wrap = f.measure([]byte("mink"))
+	f.Insert([]byte("superminky"), 0)
+	for bn, box := range f.Boxes() {
+		if box.Width() > f.Option.Wrap {
+			t.Logf("box #%d: %q is wider than the line\n", bn, box.Bytes())
+			t.Fail()
+		}
+	}
+	if have, want := f.PointOf(len("supermink")).Y, f.Origin().Y+2*f.FontHeight(); have != want {
+		t.Logf("wrapped line: want y=%d have y=%d\n", want, have)
+		t.Fail()
+	}
+}
+
+func TestFrameNewWrap(t *testing.T) {
+	f := newFrame()
+	f.Insert([]byte("the quick brown fox jumps over the lazy dog"), 0)
+	if have, want := f.Option.Wrap, 640-2*f.Origin().X; have != want {
+		t.Logf("Wrap: want %d have %d\n", want, have)
+		t.Fail()
+	}
+	n := f.NumLines()
+	f.Resize(image.Pt(640, 480))
+	if have := f.NumLines(); have != n || n != 1 {
+		t.Logf("lines: want 1 before and after Resize, have %d and %d\n", n, have)
+		t.Fail()
+	}
+}
+
+func TestBoxClean(t *testing.T) {
+	b := newBoxesFixed()
+	for i, s := range []string{"su", "per", "", "mi", "nk", "\n", "or", "\t", "so"} {
+		b.insertbox(i, []byte(s))
+	}
+	b.Clean(NewDot(image.ZP, 1000, defaultOption.Font), 0, len(b.Box))
+	want := []string{"supermink", "\n", "or", "\t", "so"}
+	if len(b.Box) != len(want) {
+		t.Logf("want %d boxes have %d\n", len(want), len(b.Box))
+		t.FailNow()
+	}
+	for bn, s := range want {
+		if have := string(b.Box[bn].Bytes()); have != s {
+			t.Logf("box #%d: want %q have %q\n", bn, s, have)
+			t.Fail()
+		}
+	}
+}
+
+func TestFrameClean(t *testing.T) {
+	f := newFrame()
+	for i := 0; i < 100; i++ {
+		f.Tick.WriteRune(rune('a' + i%26))
+	}
+	f.Tick.Write([]byte("\nmink"))
+	nlines := f.PointOf(f.Len()).Sub(f.Origin()).Y/f.FontHeight() + 1
+	if have, want := len(f.Boxes()), nlines+1; have != want {
+		t.Logf("want %d boxes on %d lines, have %d\n", want, nlines, have)
+		t.Fail()
+	}
+}
+
+func newBoxesString(s ...string) *Boxes {
+	b := newBoxesFixed()
+	for _, s := range s {
+		b.Insert([]byte(s), b.Len())
+	}
+	return b
+}
+
+func TestBoxReadAt(t *testing.T) {
+	b := newBoxesString("super", "\n", "duper\tmi", "nk")
+	for _, tc := range []struct {
+		off  int64
+		n    int
+		want string
+		err  error
+	}{
+		{0, 5, "super", nil},
+		{3, 6, "er\ndup", nil},
+		{0, 16, "super\nduper\tmink", nil},
+		{14, 2, "nk", nil},
+		{14, 4, "nk", io.EOF},
+		{16, 1, "", io.EOF},
+		{99, 1, "", io.EOF},
+		{4, 0, "", nil},
+		{-1, 1, "", ErrBadOffset},
+	} {
+		p := make([]byte, tc.n)
+		n, err := b.ReadAt(p, tc.off)
+		if have := string(p[:n]); have != tc.want || err != tc.err {
+			t.Logf("ReadAt(%d, %d): want %q, %v have %q, %v\n", tc.off, tc.n, tc.want, tc.err, have, err)
+			t.Fail()
+		}
+	}
+	if err := iotest.TestReader(io.NewSectionReader(b, 0, int64(b.Len())), []byte("super\nduper\tmink")); err != nil {
+		t.Log(err)
+		t.Fail()
+	}
+}
+
+func TestBoxWriteAt(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		off  int64
+		p    string
+		want string
+		err  error
+	}{
+		{"head", 0, "SU", "SUper\nmink", nil},
+		{"break", 4, "R\tM", "supeR\tMink", nil},
+		{"tail", 8, "nky", "super\nminky", nil},
+		{"append", 10, "\n", "super\nmink\n", nil},
+		{"past", 11, "x", "super\nmink", ErrBadOffset},
+		{"negative", -1, "x", "super\nmink", ErrBadOffset},
+	} {
+		b := newBoxesString("super\nmink")
+		_, err := b.WriteAt([]byte(tc.p), tc.off)
+		if have := string(b.Slice(0, b.Len())); have != tc.want || err != tc.err {
+			t.Logf("%s: want %q, %v have %q, %v\n", tc.name, tc.want, tc.err, have, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestBoxInsertAt(t *testing.T) {
+	b := newBoxesString("super\nmink")
+	b.InsertAt([]byte("duper"), 5)
+	if have, want := string(b.Slice(0, b.Len())), "superduper\nmink"; have != want {
+		t.Logf("want %q have %q\n", want, have)
+		t.Fail()
+	}
+}
+
+func TestBoxWriteTo(t *testing.T) {
+	want := "super\nduper\tmink"
+	b := newBoxesString("super", "\nduper", "\tmink")
+	buf := new(bytes.Buffer)
+	n, err := b.WriteTo(buf)
+	if have := buf.String(); have != want || n != int64(len(want)) || err != nil {
+		t.Logf("want %q, %d have %q, %d, %v\n", want, len(want), have, n, err)
+		t.Fail()
+	}
+}
+
+func TestBoxReadFrom(t *testing.T) {
+	want := "super\nduper\tmink"
+	b := newBoxesString("super")
+	n, err := b.ReadFrom(iotest.OneByteReader(strings.NewReader("\nduper\tmink")))
+	if have := string(b.Slice(0, b.Len())); have != want || n != int64(len(want)-5) || err != nil {
+		t.Logf("want %q, %d have %q, %d, %v\n", want, len(want)-5, have, n, err)
+		t.Fail()
+	}
+}
+
+func TestFrameOrigin(t *testing.T) {
+	f := newFrame()
+	text := newBoxesFixed()
+	for i := 0; i < 100; i++ {
+		text.Insert([]byte(fmt.Sprintf("line %d\n", i)), text.Len())
+	}
+	lines := func(i, j int) (s string) {
+		for ; i < j; i++ {
+			s += fmt.Sprintf("line %d\n", i)
+		}
+		return s
+	}
+	ck := func(want string) {
+		if have := string(f.Bytes()); have != want {
+			t.Logf("frame: want %q have %q\n", want, have)
+			t.FailNow()
+		}
+	}
+	f.SetText(text)
+	nl := f.MaxLines()
+	ck(lines(0, nl))
+	q := len(lines(0, 5))
+	if have, want := f.SetOrigin(q), len(lines(5, 5+nl)); have != want {
+		t.Logf("SetOrigin: accepted: want %d have %d\n", want, have)
+		t.Fail()
+	}
+	ck(lines(5, 5+nl))
+	f.Insert([]byte("mink\n"), 0)
+	ck("mink\n" + lines(5, 5+nl-1))
+	if have, want := string(text.Slice(q, q+len("mink\n"))), "mink\n"; have != want {
+		t.Logf("text: want %q have %q\n", want, have)
+		t.Fail()
+	}
+	f.Delete(0, len("mink\n"))
+	ck(lines(5, 5+nl))
+	f.SetOrigin(len(lines(0, 98)))
+	ck(lines(98, 100))
+
+	// an origin inside a rune moves to its start
+	text = newBoxesFixed()
+	text.Insert([]byte("世界hello"), 0)
+	f.SetText(text)
+	for q := 0; q < 3; q++ {
+		f.SetOrigin(q)
+		ck("世界hello")
+		if f.Org() != 0 {
+			t.Logf("SetOrigin(%d): want origin 0 have %d\n", q, f.Org())
+			t.Fail()
+		}
+	}
+	f.SetOrigin(4)
+	ck("界hello")
+}
+
+func TestFrameLines(t *testing.T) {
+	f := newFrame()
+	if have := f.NumLines(); have != 1 {
+		t.Logf("empty frame: want 1 line have %d\n", have)
+		t.Fail()
+	}
+	f.Option.Wrap = f.measure([]byte("mink"))
+	f.Insert([]byte("ab\nsupermink\n\ncd"), 0)
+	vis := f.NumLines()
+	if vis < 5 {
+		t.Logf("want at least 5 visual lines have %d\n", vis)
+		t.FailNow()
+	}
+	if have, want := f.NumLogicalLines(), 4; have != want {
+		t.Logf("logical lines: want %d have %d\n", want, have)
+		t.Fail()
+	}
+	for n := 0; n < vis; n++ {
+		q := f.LineStart(n)
+		if have := f.LineOf(q); have != n {
+			t.Logf("LineOf(LineStart(%d)): have %d\n", n, have)
+			t.Fail()
+		}
+		if have, want := f.PointOf(q), f.Origin().Add(image.Pt(0, n*f.FontHeight())); have != want {
+			t.Logf("PointOf(LineStart(%d)): want %v have %v\n", n, want, have)
+			t.Fail()
+		}
+	}
+	for _, tc := range []struct {
+		n, q int
+	}{
+		{0, 0}, {1, 3}, {2, 13}, {3, 14}, {4, 16},
+	} {
+		if have := f.LogicalLineStart(tc.n); have != tc.q {
+			t.Logf("LogicalLineStart(%d): want %d have %d\n", tc.n, tc.q, have)
+			t.Fail()
+		}
+		if tc.q < f.Len() {
+			if have := f.LogicalLineOf(tc.q); have != tc.n {
+				t.Logf("LogicalLineOf(%d): want %d have %d\n", tc.q, tc.n, have)
+				t.Fail()
+			}
+		}
+	}
+	if have, want := f.LogicalLineOf(len("ab\nsuperm")), 1; have != want {
+		t.Logf("LogicalLineOf inside wrapped line: want %d have %d\n", want, have)
+		t.Fail()
+	}
+	if f.Full() {
+		t.Logf("frame is full\n")
+		t.Fail()
+	}
+}
+
+func TestFrameFull(t *testing.T) {
+	f := New(image.ZP, image.Pt(200, 100), nil, nil)
+	f.Tick = NewTick(f)
+	text := NewBoxes(f.measure)
+	text.Insert([]byte(strings.Repeat("a\n", f.MaxLines()-1)+strings.Repeat("long ", 100)), 0)
+	f.SetText(text)
+
+	// the last line is cut short by the bottom of the frame,
+	// and the insertions don't push anything past it
+	for _, s := range []string{"x", "y"} {
+		f.Insert([]byte(s), 0)
+		if !f.Full() || f.NumLines() != f.MaxLines() {
+			t.Logf("insert %q: want a full frame have Full=%v with %d of %d lines\n", s, f.Full(), f.NumLines(), f.MaxLines())
+			t.Fail()
+		}
+	}
+	f.Delete(0, f.Len())
+	if !f.Full() {
+		t.Logf("delete: the refilled frame isn't full\n")
+		t.Fail()
+	}
 }
+
+func TestFrameRelayout(t *testing.T) {
+	f := newFrame()
+	f.Option.Wrap = 200
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"mink", "super", " ", "\n", "\t", "duper", "世界", "or so"}
+	for i := 0; i < 500; i++ {
+		q, p := rng.Intn(f.Len()+1), f.Bytes()
+		for q < len(p) && !utf8.RuneStart(p[q]) {
+			q--
+		}
+		if rng.Intn(3) == 0 {
+			f.Delete(q, f.boxes.NextRune(f.boxes.NextRune(q)))
+		} else {
+			f.Insert([]byte(words[rng.Intn(len(words))]), q)
+		}
+	}
+	g := newFrame()
+	g.Option.Wrap = f.Option.Wrap
+	g.Insert(f.Bytes(), 0)
+	if have, want := len(f.Boxes()), len(g.Boxes()); have != want {
+		t.Logf("boxes: want %d have %d\n", want, have)
+		t.FailNow()
+	}
+	for bn := range g.Boxes() {
+		if f.pos[bn] != g.pos[bn] || f.off[bn] != g.off[bn] {
+			t.Logf("box #%d: want %v@%d have %v@%d\n", bn, g.pos[bn], g.off[bn], f.pos[bn], f.off[bn])
+			t.FailNow()
+		}
+	}
+	if have, want := len(f.lines), len(g.lines); have != want {
+		t.Logf("lines: want %d have %d\n", want, have)
+		t.FailNow()
+	}
+	for i := 0; i <= f.Len(); i = f.boxes.NextRune(i) {
+		if have, _ := f.IndexOf(f.PointOf(i)); have != i {
+			t.Logf("IndexOf(PointOf(%d)): have %d\n", i, have)
+			t.Fail()
+		}
+		if i == f.Len() {
+			break
+		}
+	}
+}
+
+func TestFrameUndo(t *testing.T) {
+	f := newFrame()
+	tick := f.Tick
+	ck := func(want string, p0, p1 int) {
+		t.Helper()
+		if have := string(f.Bytes()); have != want {
+			t.Logf("frame: want %q have %q\n", want, have)
+			t.FailNow()
+		}
+		if tick.P0 != p0 || tick.P1 != p1 {
+			t.Logf("tick: want %d:%d have %d:%d\n", p0, p1, tick.P0, tick.P1)
+			t.FailNow()
+		}
+	}
+	for _, r := range "mink" {
+		tick.WriteRune(r)
+	}
+	tick.Write([]byte("\n"))
+	for _, r := range "or so" {
+		tick.WriteRune(r)
+	}
+	ck("mink\nor so", 10, 10)
+
+	// select "or" and replace it
+	tick.Open(5)
+	tick.P1 = 7
+	tick.Write([]byte("and"))
+	ck("mink\nand so", 8, 8)
+
+	// backspace twice
+	tick.Delete()
+	tick.Delete()
+	ck("mink\na so", 6, 6)
+
+	// a group spanning the start and end of the frame
+	f.Begin()
+	f.Insert([]byte("super"), 0)
+	f.Insert([]byte("!"), f.Len())
+	f.End()
+	ck("supermink\na so!", 6, 6)
+
+	f.Undo()
+	ck("mink\na so", 6, 6)
+	f.Undo()
+	ck("mink\nand so", 8, 8)
+	f.Undo()
+	ck("mink\nor so", 5, 7)
+	f.Undo()
+	ck("mink\n", 5, 5)
+	f.Undo()
+	ck("mink", 4, 4)
+	f.Undo()
+	ck("", 0, 0)
+	if f.Undo() {
+		t.Logf("undo: want false on an empty journal\n")
+		t.Fail()
+	}
+
+	f.Redo()
+	ck("mink", 4, 4)
+	f.Redo()
+	f.Redo()
+	ck("mink\nor so", 10, 10)
+
+	// a new edit discards the redo stack
+	tick.Open(0)
+	tick.Write([]byte(">"))
+	ck(">mink\nor so", 1, 1)
+	if f.Redo() {
+		t.Logf("redo: want false after a new edit\n")
+		t.Fail()
+	}
+	f.Undo()
+	ck("mink\nor so", 0, 0)
+
+	// an insertion past the end is journaled where it landed
+	f.Insert([]byte("!"), 100)
+	ck("mink\nor so!", 0, 0)
+	f.Undo()
+	ck("mink\nor so", 0, 0)
+}
+
+func TestFrameTabstop(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opt  func(*Option)
+	}{
+		{"default", func(o *Option) {}},
+		{"spaces", func(o *Option) { o.Tabstop = 8 }},
+		{"pixels", func(o *Option) { o.TabstopPx = 50 }},
+	} {
+		opt := *defaultOption
+		opt.Wrap = 1000
+		tc.opt(&opt)
+		f := New(image.ZP, image.Pt(1000, 480), nil, &opt)
+		f.Tick = NewTick(f)
+		f.Insert([]byte("a\tb\nc\tb\n\tb\na\tc\tb"), 0)
+		tab := f.tabstop()
+		col := func(n int) int { return f.Origin().X + n*tab }
+		for _, want := range []struct {
+			q, x int
+		}{
+			{2, col(1)}, {6, col(1)}, {9, col(1)}, {13, col(1)}, {15, col(2)},
+		} {
+			if have := f.PointOf(want.q).X; have != want.x {
+				t.Logf("%s: PointOf(%d).X: want %d have %d\n", tc.name, want.q, want.x, have)
+				t.Fail()
+			}
+			if have, _ := f.IndexOf(f.PointOf(want.q)); have != want.q {
+				t.Logf("%s: IndexOf(PointOf(%d)): have %d\n", tc.name, want.q, have)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestFrameElastic(t *testing.T) {
+	opt := *defaultOption
+	opt.Wrap = 1000
+	opt.Elastic = true
+	f := New(image.ZP, image.Pt(1000, 480), nil, &opt)
+	f.Tick = NewTick(f)
+	f.Insert([]byte("a\tb\nabcdefgh\tc\n\nx\ty"), 0)
+	x := func(q int) int { return f.PointOf(q).X }
+	if x(2) != x(13) {
+		t.Logf("column: b at %d, c at %d\n", x(2), x(13))
+		t.Fail()
+	}
+	if x(2) <= x(12) {
+		t.Logf("column: b at %d, not past text ending at %d\n", x(2), x(12))
+		t.Fail()
+	}
+	if have, want := x(18), f.Origin().X+f.tabstop(); have != want {
+		t.Logf("separate block: want y at %d have %d\n", want, have)
+		t.Fail()
+	}
+
+	// widening a cell widens its column; removing the tab
+	// takes the line out of the block
+	b := x(2)
+	f.Insert([]byte("ijkl"), 12)
+	if x(2) <= b || x(2) != x(17) {
+		t.Logf("widen: b at %d (was %d), c at %d\n", x(2), b, x(17))
+		t.Fail()
+	}
+	f.Delete(16, 17)
+	if have, want := x(2), f.Origin().X+f.tabstop(); have != want {
+		t.Logf("part: want b at %d have %d\n", want, have)
+		t.Fail()
+	}
+
+	g := New(image.ZP, image.Pt(1000, 480), nil, &opt)
+	g.Insert(f.Bytes(), 0)
+	for q := 0; q <= f.Len(); q++ {
+		if have, want := f.PointOf(q), g.PointOf(q); have != want {
+			t.Logf("PointOf(%d): want %v have %v\n", q, want, have)
+			t.Fail()
+		}
+		if have, _ := f.IndexOf(f.PointOf(q)); have != q {
+			t.Logf("IndexOf(PointOf(%d)): have %d\n", q, have)
+			t.Fail()
+		}
+	}
+
+	// the widths of deleted tabs are forgotten
+	for i := 0; i < 50; i++ {
+		f.Insert([]byte("\t"), 0)
+		f.Delete(0, 1)
+	}
+	ntab := 0
+	for _, box := range f.Boxes() {
+		if box.BreakChar() == '\t' {
+			ntab++
+		}
+	}
+	if len(f.tabs) > ntab {
+		t.Logf("tab widths: %d kept for %d tabs\n", len(f.tabs), ntab)
+		t.Fail()
+	}
+
+	// an edit is retabbed from the start of its block
+	g = New(image.ZP, image.Pt(1000, 480), nil, &opt)
+	g.Insert([]byte("a\tb\nc\td\n\ne\tf\ng\th"), 0)
+	q0 := len("a\tb\nc\td\n\n")
+	for _, tc := range []struct{ i, want int }{
+		{5, 0}, {q0 + 5, q0}, {q0 - 1, 0},
+	} {
+		if have := g.retab(tc.i, tc.i); have != tc.want {
+			t.Logf("retab(%d): want %d have %d\n", tc.i, tc.want, have)
+			t.Fail()
+		}
+	}
+}
+
+func TestFrameWrapMode(t *testing.T) {
+	for _, tc := range []struct {
+		mode WrapMode
+		q    int
+		line int
+	}{
+		{WrapChar, 12, 1},
+		{WrapWord, 11, 1},
+		{WrapWord, 10, 0},
+		{WrapNone, 15, 0},
+	} {
+		f := newFrame()
+		f.Option.Wrap = f.measure([]byte("mink super d"))
+		f.WrapMode = tc.mode
+		f.Insert([]byte("mink super duper"), 0)
+		if have := f.LineOf(tc.q); have != tc.line {
+			t.Logf("mode %d: LineOf(%d): want %d have %d\n", tc.mode, tc.q, tc.line, have)
+			t.Fail()
+		}
+		for q := 0; q <= f.Len(); q++ {
+			if have, _ := f.IndexOf(f.PointOf(q)); have != q {
+				t.Logf("mode %d: IndexOf(PointOf(%d)): have %d\n", tc.mode, q, have)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestFrameWordRelayout(t *testing.T) {
+	f := newFrame()
+	f.Option.Wrap = 200
+	f.WrapMode = WrapWord
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"mink", "super", " ", " ", "\n", "\t", "duper", "世界", "or so"}
+	for i := 0; i < 500; i++ {
+		q, p := rng.Intn(f.Len()+1), f.Bytes()
+		for q < len(p) && !utf8.RuneStart(p[q]) {
+			q--
+		}
+		if rng.Intn(3) == 0 {
+			f.Delete(q, f.boxes.NextRune(f.boxes.NextRune(q)))
+		} else {
+			f.Insert([]byte(words[rng.Intn(len(words))]), q)
+		}
+	}
+	g := newFrame()
+	g.Option.Wrap = f.Option.Wrap
+	g.WrapMode = WrapWord
+	g.Insert(f.Bytes(), 0)
+	for q := 0; q <= f.Len(); q = f.boxes.NextRune(q) {
+		if have, want := f.PointOf(q), g.PointOf(q); have != want {
+			t.Logf("PointOf(%d): want %v have %v\n", q, want, have)
+			t.FailNow()
+		}
+		if q == f.Len() {
+			break
+		}
+	}
+}
+
+// panframe adapts a frame to Sc's Handler
+type panframe struct {
+	*Frame
+}
+
+func (p panframe) Draw() {
+	p.Frame.Draw(true)
+}
+
+func TestFrameXOffset(t *testing.T) {
+	f := newFrame()
+	f.WrapMode = WrapNone
+	long := strings.Repeat("mink ", 100)
+	f.Insert([]byte("short\n"+long+"\nshort"), 0)
+	if have, want := f.NumLines(), 3; have != want {
+		t.Logf("lines: want %d have %d\n", want, have)
+		t.Fail()
+	}
+	if have, want := f.Extent(), f.measure([]byte(long)); have != want {
+		t.Logf("extent: want %d have %d\n", want, have)
+		t.Fail()
+	}
+	q := len("short\n") + 50
+	pt := f.PointOf(q)
+	if have := f.SetXOffset(40); have != 40 {
+		t.Logf("SetXOffset(40): have %d\n", have)
+		t.Fail()
+	}
+	if have, want := f.PointOf(q), pt.Sub(image.Pt(40, 0)); have != want {
+		t.Logf("PointOf(%d): want %v have %v\n", q, want, have)
+		t.Fail()
+	}
+	if have, _ := f.IndexOf(f.PointOf(q)); have != q {
+		t.Logf("IndexOf(PointOf(%d)): have %d\n", q, have)
+		t.Fail()
+	}
+	if have, want := f.SetXOffset(1<<20), f.Extent()-f.size.X; have != want {
+		t.Logf("SetXOffset clamp: want %d have %d\n", want, have)
+		t.Fail()
+	}
+
+	s := NewSc(panframe{f}, image.ZP, f.size, 10, MenuColor, MenuColor)
+	f.SetXOffset(0)
+	hb := s.HBarBounds()
+	s.Clickhsb(image.Pt(hb.Min.X+hb.Dx()/2, hb.Min.Y), DirNone)
+	if f.XOffset() == 0 {
+		t.Logf("Clickhsb: frame not scrolled\n")
+		t.Fail()
+	}
+	e := s.EmbedBounds()
+	if x, y := s.Project(float32(e.Min.X+7), float32(e.Min.Y+3)); x != 7 || y != 3 {
+		t.Logf("Project: want (7,3) have (%v,%v)\n", x, y)
+		t.Fail()
+	}
+	if e.Max.Y > hb.Min.Y {
+		t.Logf("frame %v overlaps scrollbar %v\n", e, hb)
+		t.Fail()
+	}
+
+	// there's no scrollbar if the lines fit or are wrapped
+	for _, mode := range []WrapMode{WrapNone, WrapChar, WrapWord} {
+		g := newFrame()
+		g.WrapMode = mode
+		if mode == WrapNone {
+			g.Insert([]byte("short\nshort"), 0)
+		} else {
+			g.Insert([]byte(long), 0)
+		}
+		s := NewSc(panframe{g}, image.ZP, g.size, 10, MenuColor, MenuColor)
+		if hb := s.HBarBounds(); hb != image.ZR {
+			t.Logf("mode %d: want no scrollbar have %v\n", mode, hb)
+			t.Fail()
+		}
+		if have, want := s.EmbedBounds().Dy(), g.size.Y; have != want {
+			t.Logf("mode %d: frame height: want %d have %d\n", mode, want, have)
+			t.Fail()
+		}
+	}
+}
+
+func TestFrameColumns(t *testing.T) {
+	ttf, err := truetype.Parse(gomono.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := *defaultOption
+	opt.Font = NewFont(truetype.NewFace(ttf, &truetype.Options{Size: 12}))
+	opt.Columns = 10
+	f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+	f.Tick = NewTick(f)
+	dx, _ := f.Font.GlyphAdvance('m')
+	if have, want := f.Font.ColumnWidth(), int(dx>>6); have != want {
+		t.Logf("ColumnWidth: want %d have %d\n", want, have)
+		t.Fail()
+	}
+	f.Insert([]byte(strings.Repeat("m", 25)), 0)
+	for _, size := range []image.Point{{640, 480}, {300, 480}, {1000, 480}} {
+		f.Resize(size)
+		for q, want := range map[int]int{9: 0, 10: 1, 19: 1, 20: 2} {
+			if have := f.LineOf(q); have != want {
+				t.Logf("%v: LineOf(%d): want %d have %d\n", size, q, want, have)
+				t.Fail()
+			}
+		}
+	}
+
+	f.Columns = 0
+	f.Resize(image.Pt(f.measure([]byte("mmmmm")), 480))
+	if have, want := f.LineOf(5), 1; have != want {
+		t.Logf("pixel wrap: LineOf(5): want %d have %d\n", want, have)
+		t.Fail()
+	}
+}
+
+// kernface kerns every pair of capitals by -3 pixels
+type kernface struct {
+	font.Face
+}
+
+func (k kernface) Kern(r0, r1 rune) fixed.Int26_6 {
+	if unicode.IsUpper(r0) && unicode.IsUpper(r1) {
+		return fixed.I(-3)
+	}
+	return 0
+}
+
+func TestFrameKerning(t *testing.T) {
+	face := NewFont(kernface{parseDefaultFont(12)})
+	s := "xAVAVx"
+	for _, kerning := range []bool{false, true} {
+		opt := *defaultOption
+		opt.Font = face
+		opt.Kerning = kerning
+		f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+		f.Tick = NewTick(f)
+		f.Insert([]byte(s), 0)
+		x, prev := f.Origin().X, rune(-1)
+		for q, r := range s {
+			if kerning && prev >= 0 {
+				x += face.Kern(prev, r).Round()
+			}
+			if have := f.PointOf(q).X; have != x {
+				t.Logf("kerning=%v: PointOf(%d).X: want %d have %d\n", kerning, q, x, have)
+				t.Fail()
+			}
+			if have, _ := f.IndexOf(f.PointOf(q)); have != q {
+				t.Logf("kerning=%v: IndexOf(PointOf(%d)): have %d\n", kerning, q, have)
+				t.Fail()
+			}
+			dx, _ := face.GlyphAdvance(r)
+			x += int(dx >> 6)
+			prev = r
+		}
+		if have := f.PointOf(len(s)).X; have != x {
+			t.Logf("kerning=%v: end: want %d have %d\n", kerning, x, have)
+			t.Fail()
+		}
+	}
+}
+
+// holeface is missing the glyph for x
+type holeface struct {
+	font.Face
+}
+
+func (h holeface) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	if r == 'x' {
+		return
+	}
+	return h.Face.Glyph(dot, r)
+}
+
+func (h holeface) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	if r == 'x' {
+		return
+	}
+	return h.Face.GlyphAdvance(r)
+}
+
+func TestFrameFallback(t *testing.T) {
+	adv := func(face font.Face, r rune) int {
+		dx, _ := face.GlyphAdvance(r)
+		return int(dx >> 6)
+	}
+	primary, large := parseDefaultFont(12), parseDefaultFont(24)
+	for _, tc := range []struct {
+		name string
+		font *Font
+		want int
+	}{
+		{"fallback", NewFont(holeface{primary}, large), 2*adv(primary, 'a') + adv(large, 'x')},
+		{"replacement", NewFont(holeface{primary}), 2*adv(primary, 'a') + adv(primary, utf8.RuneError)},
+	} {
+		opt := *defaultOption
+		opt.Font = tc.font
+		f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+		f.Tick = NewTick(f)
+		if have := f.measure([]byte("axa")); have != tc.want {
+			t.Logf("%s: measure: want %d have %d\n", tc.name, tc.want, have)
+			t.Fail()
+		}
+
+		// the glyphs after the missing one are still drawn
+		f.Insert([]byte("xxxa"), 0)
+		f.Redraw(false)
+		p, q := f.PointOf(3), f.PointOf(4)
+		r := image.Rect(p.X, p.Y, q.X, q.Y+f.FontHeight())
+		back := f.disp.At(r.Min.X, r.Min.Y)
+		drawn := false
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				drawn = drawn || f.disp.At(x, y) != back
+			}
+		}
+		if !drawn {
+			t.Logf("%s: nothing drawn in %v after the missing glyphs\n", tc.name, r)
+			t.Fail()
+		}
+	}
+}
+
+func TestFrameLeading(t *testing.T) {
+	for _, leading := range []int{0, 6, -2} {
+		opt := *defaultOption
+		opt.Leading = leading
+		f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+		f.Tick = NewTick(f)
+		if have, want := f.FontHeight(), f.Font.Height()+leading; have != want {
+			t.Logf("leading %d: FontHeight: want %d have %d\n", leading, want, have)
+			t.Fail()
+		}
+		f.Insert([]byte("M\nM\nM"), 0)
+		for q, line := range []int{0, 0, 1, 1, 2} {
+			if have, want := f.PointOf(q).Y, f.Origin().Y+line*f.FontHeight(); have != want {
+				t.Logf("leading %d: PointOf(%d).Y: want %d have %d\n", leading, q, want, have)
+				t.Fail()
+			}
+			if have, _ := f.IndexOf(f.PointOf(q)); have != q {
+				t.Logf("leading %d: IndexOf(PointOf(%d)): have %d\n", leading, q, have)
+				t.Fail()
+			}
+		}
+
+		// the M on the second line stands on the baseline
+		f.Redraw(false)
+		p, q := f.PointOf(2), f.PointOf(3)
+		back := f.disp.At(0, f.disp.Bounds().Max.Y-1)
+		bottom := -1
+		for y := p.Y; y < p.Y+f.FontHeight(); y++ {
+			for x := p.X; x < q.X; x++ {
+				if f.disp.At(x, y) != back {
+					bottom = y
+				}
+			}
+		}
+		if want := p.Y + f.baseline() - 1; bottom < want-1 || bottom > want {
+			t.Logf("leading %d: glyph ends at y=%d, want baseline at %d\n", leading, bottom, want+1)
+			t.Fail()
+		}
+	}
+}
+
+func TestFrameDescenders(t *testing.T) {
+	for _, size := range []float64{12, 16, 24} {
+		opt := *defaultOption
+		opt.Font = NewFont(parseDefaultFont(size))
+		f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+		f.Tick = NewTick(f)
+		f.Insert([]byte("gjpqy"), 0)
+		f.Draw(true)
+		r := f.lineRect(0)
+		back := f.disp.At(0, f.disp.Bounds().Max.Y-1)
+		below := false
+		b := f.disp.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				drawn := f.disp.At(x, y) != back
+				if drawn && !image.Pt(x, y).In(r) {
+					t.Logf("size %v: pixel (%d,%d) drawn outside the line %v\n", size, x, y, r)
+					t.FailNow()
+				}
+				below = below || drawn && y >= r.Min.Y+f.baseline()
+			}
+		}
+		if !below {
+			t.Logf("size %v: no descenders drawn below the baseline\n", size)
+			t.Fail()
+		}
+	}
+}
+
+func TestFrameShowControl(t *testing.T) {
+	face := defaultOption.Font
+	adv := func(s string) (w int) {
+		for _, r := range s {
+			dx, _ := face.GlyphAdvance(r)
+			w += int(dx >> 6)
+		}
+		return w
+	}
+	for _, tc := range []struct {
+		mode ControlMode
+		s    string
+		want int
+	}{
+		{ControlCaret, "\x01", adv("^A")},
+		{ControlCaret, "\x7f", adv("^?")},
+		{ControlCaret, "\u0085", adv("85") + 2*hexpad},
+		{ControlHex, "\x1b", adv("1B") + 2*hexpad},
+	} {
+		opt := *defaultOption
+		opt.ShowControl = tc.mode
+		f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+		f.Tick = NewTick(f)
+		if have := f.measure([]byte(tc.s)); have != tc.want {
+			t.Logf("mode %d: measure(%q): want %d have %d\n", tc.mode, tc.s, tc.want, have)
+			t.Fail()
+		}
+		s := "a" + tc.s + "b" + tc.s
+		f.Insert([]byte(s), 0)
+		f.Redraw(false)
+		for q := 0; q <= len(s); q = f.boxes.NextRune(q) {
+			if have, _ := f.IndexOf(f.PointOf(q)); have != q {
+				t.Logf("mode %d: IndexOf(PointOf(%d)): have %d\n", tc.mode, q, have)
+				t.Fail()
+			}
+			if q == len(s) {
+				break
+			}
+		}
+	}
+}
+
+func TestFrameShowSpace(t *testing.T) {
+	inked := func(f *Frame, i, j int) bool {
+		p, q := f.PointOf(i), f.PointOf(j)
+		if j == i+1 && f.Slice(i, j)[0] == '\n' {
+			q = p.Add(image.Pt(f.FontHeight(), 0))
+		}
+		back := f.disp.At(f.disp.Bounds().Max.X-1, f.disp.Bounds().Max.Y-1)
+		// the neighbouring glyphs can overhang by a pixel
+		for y := p.Y; y < p.Y+f.FontHeight(); y++ {
+			for x := p.X + 1; x < q.X-1; x++ {
+				if f.disp.At(x, y) != back {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, show := range []bool{false, true} {
+		opt := *defaultOption
+		opt.ShowSpace = show
+		f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+		f.Tick = NewTick(f)
+		f.Insert([]byte("a b  \n\tc"), 0)
+		f.Redraw(false)
+		for _, tc := range []struct {
+			i, j int
+			want bool
+		}{
+			{1, 2, false},
+			{3, 5, show},
+			{5, 6, show},
+			{6, 7, show},
+		} {
+			if have := inked(f, tc.i, tc.j); have != tc.want {
+				t.Logf("show=%v: %q marked: want %v have %v\n", show, f.Slice(tc.i, tc.j), tc.want, have)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestFrameScale(t *testing.T) {
+	opt := *defaultOption
+	opt.Font = NewFont(parseDefaultFont(24))
+	g := New(image.ZP, image.Pt(1280, 960), nil, &opt)
+	g.Tick = NewTick(g)
+	opt.Scale = image.Pt(2, 2)
+	f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+	f.Tick = NewTick(f)
+	f.Resize(image.Pt(640, 480))
+	g.Resize(image.Pt(1280, 960))
+	if have, want := f.RGBA().Bounds(), g.RGBA().Bounds(); have != want {
+		t.Logf("canvas: want %v have %v\n", want, have)
+		t.Fail()
+	}
+	if have, want := f.Height(), (g.FontHeight()+1)/2; have != want {
+		t.Logf("Height: want %d have %d\n", want, have)
+		t.Fail()
+	}
+	s := strings.Repeat("the quick brown fox\n\tjumps over the lazy dog ", 10)
+	f.Insert([]byte(s), 0)
+	g.Insert([]byte(s), 0)
+	for q := 0; q <= len(s); q++ {
+		if have, want := f.PointOf(q), g.PointOf(q).Div(2); have != want {
+			t.Logf("PointOf(%d): want %v have %v\n", q, want, have)
+			t.FailNow()
+		}
+		if have, _ := f.IndexOf(f.PointOf(q)); have != q {
+			t.Logf("IndexOf(PointOf(%d)): have %d\n", q, have)
+			t.Fail()
+		}
+	}
+}
+
+func TestFrameScaleTabs(t *testing.T) {
+	narrow := 0
+	for _, scale := range []int{2, 3} {
+		// sweep the tab stops so some tabs end just past the text
+		for stop := 40; stop < 60; stop++ {
+			opt := *defaultOption
+			opt.Scale = image.Pt(scale, scale)
+			opt.TabstopPx = stop
+			f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+			f.Tick = NewTick(f)
+			s := ""
+			for k := 1; k < 40; k++ {
+				s += strings.Repeat("i", k) + "\tx\tm\n"
+			}
+			f.Insert([]byte(s), 0)
+			for bn := range f.off {
+				if b := f.Box(bn); b.BreakChar() == '\t' && b.Width() <= 2 {
+					narrow++
+				}
+			}
+			for q := 0; q <= f.Len(); q++ {
+				pt := f.PointOf(q)
+				if q > 0 && f.PointOf(q-1) == pt || q < f.Len() && f.PointOf(q+1) == pt {
+					continue // the glyphs share a logical point
+				}
+				if have, _ := f.IndexOf(pt); have != q {
+					t.Logf("scale %d stop %d: IndexOf(PointOf(%d)): have %d\n", scale, stop, q, have)
+					t.Fail()
+				}
+			}
+		}
+	}
+	if narrow == 0 {
+		t.Logf("no tab was narrower than three pixels\n")
+		t.Fail()
+	}
+}
+
+func TestFrameZoom(t *testing.T) {
+	f := newFrame()
+	f.Insert([]byte("MMMM\nMMMM"), 0)
+	f.Draw(true)
+	back := f.disp.At(f.disp.Bounds().Max.X-1, f.disp.Bounds().Max.Y-1)
+	var at image.Point
+	p, q := f.PointOf(5), f.PointOf(9)
+	for y := p.Y; y < p.Y+f.FontHeight() && at == image.ZP; y++ {
+		for x := p.X; x < q.X; x++ {
+			if f.disp.At(x, y) != back && f.disp.At(x+1, y) != back && f.disp.At(x, y+1) != back {
+				at = image.Pt(x, y)
+				break
+			}
+		}
+	}
+	if at == image.ZP {
+		t.Fatal("no glyph drawn")
+	}
+
+	f.ZoomBy(2, at)
+	if have := f.Zoom(); have != 2 {
+		t.Logf("Zoom: want 2 have %v\n", have)
+		t.Fail()
+	}
+	if x, y := f.unzoom(float32(at.X), float32(at.Y)); x != float32(at.X) || y != float32(at.Y) {
+		t.Logf("unzoom(%v): the anchor moved to (%v,%v)\n", at, x, y)
+		t.Fail()
+	}
+	if x, y := f.unzoom(float32(at.X+10), float32(at.Y+4)); x != float32(at.X+5) || y != float32(at.Y+2) {
+		t.Logf("unzoom(%v): want %v have (%v,%v)\n", at.Add(image.Pt(10, 4)), at.Add(image.Pt(5, 2)), x, y)
+		t.Fail()
+	}
+	f.Draw(true)
+	if f.RGBA() == f.disp {
+		t.Fatal("zoomed frame shows the unzoomed canvas")
+	}
+	if f.RGBA().At(at.X, at.Y) == back {
+		t.Logf("the glyph under %v moved when zooming\n", at)
+		t.Fail()
+	}
+
+	f.ZoomBy(100, at)
+	if have := f.Zoom(); have != maxZoom {
+		t.Logf("Zoom: want %v have %v\n", float64(maxZoom), have)
+		t.Fail()
+	}
+	f.ResetZoom()
+	if f.RGBA() != f.disp {
+		t.Logf("reset frame shows the zoomed view\n")
+		t.Fail()
+	}
+}
+
+func TestFrameDrawDamage(t *testing.T) {
+	f := newFrame()
+	f.Insert([]byte("one\ntwo\nthree\nfour\nfive"), 0)
+	f.Draw(true)
+
+	// a line that's redrawn or cleared loses the mark at its
+	// right edge, and a line that's copied takes it along
+	mark := color.RGBA{255, 0, 255, 255}
+	x := f.disp.Bounds().Max.X - 1
+	remark := func() {
+		for n := 0; n < 8; n++ {
+			f.disp.Set(x, f.lineRect(n).Min.Y, mark)
+		}
+	}
+	ck := func(step string, damaged ...int) {
+		t.Helper()
+		for n := 0; n < 8; n++ {
+			want := true
+			for _, d := range damaged {
+				want = want && n != d
+			}
+			if have := f.disp.At(x, f.lineRect(n).Min.Y) == mark; have != want {
+				t.Logf("%s: line %d: want marked=%v have %v\n", step, n, want, have)
+				t.Fail()
+			}
+		}
+		g := newFrame()
+		g.Insert(f.Bytes(), 0)
+		g.Draw(true)
+		r := f.disp.Bounds()
+		r.Max.X = x
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if f.disp.At(x, y) != g.disp.At(x, y) {
+					t.Logf("%s: pixel (%d,%d) differs from a full redraw\n", step, x, y)
+					t.FailNow()
+				}
+			}
+		}
+	}
+
+	remark()
+	f.Insert([]byte("x"), 4)
+	f.Draw(false)
+	ck("insert", 1)
+
+	remark()
+	f.Insert([]byte("\n"), 0)
+	f.Draw(false)
+	ck("insert newline", 0)
+
+	remark()
+	f.Delete(0, 5)
+	f.Draw(false)
+	ck("delete lines", 0, 4, 5)
+}
+
+func TestFrameDrawDescenders(t *testing.T) {
+	for _, leading := range []int{0, -4} {
+		opt := *defaultOption
+		opt.Leading = leading
+		f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+		f.Tick = NewTick(f)
+		f.Insert([]byte("ab\nyg jpq\nxx"), 0)
+		f.Draw(true)
+		for _, s := range []string{"é", "\n", "Ág"} {
+			f.Insert([]byte(s), 3)
+			f.Draw(false)
+			g := New(image.ZP, image.Pt(640, 480), nil, &opt)
+			g.Tick = NewTick(g)
+			g.Insert(f.Bytes(), 0)
+			g.Draw(true)
+			if !bytes.Equal(f.disp.Pix, g.disp.Pix) {
+				t.Logf("leading %d: insert %q: incremental draw differs from a full redraw\n", leading, s)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestFrameDamage(t *testing.T) {
+	f := newFrame()
+	f.Insert([]byte("one\ntwo\nthree\nfour\nfive"), 0)
+	f.Draw(true)
+	ck := func(step string, want ...image.Rectangle) {
+		t.Helper()
+		have := f.Damage()
+		if len(have) != len(want) {
+			t.Logf("%s: damage: want %v have %v\n", step, want, have)
+			t.FailNow()
+		}
+		for i := range want {
+			if have[i] != want[i] {
+				t.Logf("%s: damage: want %v have %v\n", step, want, have)
+				t.Fail()
+			}
+		}
+		f.CleanDamage()
+	}
+	span := func(n, m int) image.Rectangle {
+		return f.lineRect(n).Union(f.lineRect(m))
+	}
+	ck("draw", f.disp.Bounds())
+
+	f.Draw(false)
+	ck("no change")
+
+	f.Insert([]byte("x"), 4)
+	f.Draw(false)
+	ck("insert", span(1, 1))
+
+	// the lines below are copied down, and the new line drawn
+	// above them
+	before := image.NewRGBA(f.disp.Bounds())
+	draw.Draw(before, before.Bounds(), f.disp, image.ZP, draw.Src)
+	f.Insert([]byte("\n"), 0)
+	f.Draw(false)
+	r := f.Damage()
+	for y := before.Bounds().Min.Y; y < before.Bounds().Max.Y; y++ {
+		for x := before.Bounds().Min.X; x < before.Bounds().Max.X; x++ {
+			if f.disp.At(x, y) == before.At(x, y) {
+				continue
+			}
+			if len(r) == 0 || !image.Pt(x, y).In(r[0]) {
+				t.Logf("insert newline: pixel (%d,%d) changed outside the damage %v\n", x, y, r)
+				t.FailNow()
+			}
+		}
+	}
+	ck("insert newline", span(0, 5))
+
+	f.Delete(0, 5)
+	f.Draw(false)
+	ck("delete lines", span(0, 5))
+
+	f.ZoomBy(2, image.Pt(10, 10))
+	f.Draw(false)
+	ck("zoom", f.disp.Bounds())
+	f.ResetZoom()
+	ck("reset zoom", f.disp.Bounds())
+}
+
+func TestFrameStyle(t *testing.T) {
+	f := newFrame()
+	f.Insert([]byte("one two three"), 0)
+	green := color.RGBA{0, 255, 0, 255}
+	red := Style{Text: image.NewUniform(green), Back: image.NewUniform(color.RGBA{255, 0, 0, 255})}
+	f.SetStyle(4, 7, red)
+	ck := func(step string, i, j int) {
+		t.Helper()
+		for q := 0; q < f.Len(); q++ {
+			st, ok := f.StyleAt(q)
+			if want := q >= i && q < j; ok != want || ok && st != red {
+				t.Logf("%s: StyleAt(%d): want styled=%v have %v\n", step, q, want, ok)
+				t.Fail()
+			}
+		}
+	}
+	ck("set", 4, 7)
+	f.Insert([]byte("xx"), 0)
+	ck("insert before", 6, 9)
+	f.Insert([]byte("yy"), 7)
+	ck("insert inside", 6, 11)
+	f.Insert([]byte("zz"), 11)
+	ck("insert after", 6, 11)
+	f.Delete(4, 8)
+	ck("delete across", 4, 7)
+	f.ClearStyle(5, 6)
+	if _, ok := f.StyleAt(5); ok {
+		t.Logf("ClearStyle: offset 5 is still styled\n")
+		t.Fail()
+	}
+	f.Delete(0, f.Len())
+	if len(f.styles) != 0 {
+		t.Logf("delete all: runs left: %v\n", f.styles)
+		t.Fail()
+	}
+
+	// the styled run is drawn in its colors, and the rest of
+	// the box in the frame's
+	f.Insert([]byte("MMMMMM"), 0)
+	f.Draw(true)
+	f.SetStyle(2, 4, red)
+	f.Draw(false)
+	y := f.PointOf(0).Y + 1
+	for q := 0; q < 5; q++ {
+		x0, x1 := f.PointOf(q).X, f.PointOf(q+1).X
+		want := f.Colors.Back.At(0, 0)
+		if q >= 2 && q < 4 {
+			want = red.Back.At(0, 0)
+		}
+		if have := f.disp.At((x0+x1)/2, y); !sameColor(have, want) {
+			t.Logf("background of %d: want %v have %v\n", q, want, have)
+			t.Fail()
+		}
+	}
+	var lit bool
+	for x := f.PointOf(2).X; x < f.PointOf(4).X; x++ {
+		for y := f.PointOf(2).Y; y < f.PointOf(2).Y+f.FontHeight(); y++ {
+			lit = lit || sameColor(f.disp.At(x, y), green)
+		}
+	}
+	if !lit {
+		t.Logf("styled glyphs aren't drawn in the run's text color\n")
+		t.Fail()
+	}
+}
+
+func TestFrameStyleUndo(t *testing.T) {
+	f := newFrame()
+	text := newBoxesFixed()
+	for i := 0; i < 100; i++ {
+		text.Insert([]byte(fmt.Sprintf("line %d\n", i)), text.Len())
+	}
+	f.SetText(text)
+	f.Insert([]byte("ZZZ"), 0)
+	f.SetOrigin(700)
+	f.SetStyle(0, 4, Style{Back: image.White})
+	want := string(text.Slice(700, 704))
+
+	// the edits replayed above the origin move the text under
+	// the run, and the run with it
+	ck := func(step string) {
+		t.Helper()
+		if len(f.styles) != 1 {
+			t.Logf("%s: runs: want 1 have %v\n", step, f.styles)
+			t.FailNow()
+		}
+		r := f.styles[0].Range
+		if have := string(text.Slice(r.I, r.J)); have != want {
+			t.Logf("%s: styled text: want %q have %q\n", step, want, have)
+			t.Fail()
+		}
+	}
+	f.Undo()
+	ck("undo")
+	f.Redo()
+	ck("redo")
+}
+
+func sameColor(c0, c1 color.Color) bool {
+	r0, g0, b0, a0 := c0.RGBA()
+	r1, g1, b1, a1 := c1.RGBA()
+	return r0 == r1 && g0 == g1 && b0 == b1 && a0 == a1
+}
+
+func TestFrameDamageCopy(t *testing.T) {
+	for _, leading := range []int{0, -4} {
+		opt := *defaultOption
+		opt.Leading = leading
+		f := New(image.ZP, image.Pt(640, 480), nil, &opt)
+		f.Tick = NewTick(f)
+		f.Insert([]byte("ab\nyg jpq\nxx"), 0)
+		f.Draw(true)
+
+		// a host that copies only the damage sees what a full
+		// redraw draws
+		screen := image.NewRGBA(f.disp.Bounds())
+		for _, s := range []string{"é", "\n", "Ág"} {
+			f.Insert([]byte(s), 3)
+			f.Draw(false)
+			for _, r := range f.Damage() {
+				draw.Draw(screen, r, f.RGBA(), r.Min, draw.Src)
+			}
+			f.CleanDamage()
+			g := New(image.ZP, image.Pt(640, 480), nil, &opt)
+			g.Tick = NewTick(g)
+			g.Insert(f.Bytes(), 0)
+			g.Draw(true)
+			if !bytes.Equal(screen.Pix, g.disp.Pix) {
+				t.Logf("leading %d: insert %q: the damage misses changed pixels\n", leading, s)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestGlyphCache(t *testing.T) {
+	ft := NewFont(parseDefaultFont(12))
+	ft.CacheSize = 4096
+	dst := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for r := rune('!'); r <= '~'; r++ {
+		ft.drawglyph(dst, fixed.P(10, 50), r, image.Black, image.ZP)
+	}
+	c := ft.cache()
+	if c.size > c.max {
+		t.Logf("cache holds %d bytes, limit %d\n", c.size, c.max)
+		t.Fail()
+	}
+	if _, ok := c.get(glyphkey{0, '~', fixed.Point26_6{}}); !ok {
+		t.Logf("most recent glyph was evicted\n")
+		t.Fail()
+	}
+	if _, ok := c.get(glyphkey{0, '!', fixed.Point26_6{}}); ok {
+		t.Logf("least recent glyph was kept\n")
+		t.Fail()
+	}
+
+	// a cached glyph draws the same pixels as the face
+	want := image.NewRGBA(dst.Bounds())
+	have := image.NewRGBA(dst.Bounds())
+	fp := fixed.Point26_6{X: fixed.I(10) + 16, Y: fixed.I(50)}
+	dr, mask, maskp, _, _ := ft.Face.Glyph(fp, 'g')
+	draw.DrawMask(want, dr, image.Black, image.ZP, mask, maskp, draw.Over)
+	ft.drawglyph(image.NewRGBA(dst.Bounds()), fp, 'g', image.Black, image.ZP)
+	ft.drawglyph(have, fp, 'g', image.Black, image.ZP)
+	if !bytes.Equal(have.Pix, want.Pix) {
+		t.Logf("cached glyph differs from the face's\n")
+		t.Fail()
+	}
+}
+
+func benchmarkRedraw(b *testing.B, cachesize int) {
+	opt := *defaultOption
+	opt.Font = NewFont(parseDefaultFont(12))
+	opt.Font.CacheSize = cachesize
+	f := New(image.ZP, image.Pt(1024, 768), nil, &opt)
+	f.Tick = NewTick(f)
+	f.Resize(image.Pt(1024, 768))
+	for !f.Full() && f.NumLines() < f.MaxLines() {
+		f.Insert([]byte("func (f *Frame) Redraw(selecting bool) {\n\tdraw.Draw(f.disp, f.Bounds())\n}\n"), f.Len())
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Redraw(false)
+	}
+}
+
+func BenchmarkRedraw(b *testing.B)        { benchmarkRedraw(b, 0) }
+func BenchmarkRedrawNoCache(b *testing.B) { benchmarkRedraw(b, -1) }
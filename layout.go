@@ -0,0 +1,271 @@
+package frame
+
+import (
+	"image"
+	"sort"
+	"unicode/utf8"
+)
+
+// nowrap is the margin of a frame that never wraps
+const nowrap = 1 << 24
+
+// line records where a visual line begins. A hard line
+// follows a newline or begins the frame.
+type line struct {
+	q    int
+	bn   int
+	hard bool
+
+	// width is the distance from the origin to the end of
+	// the last glyph on the line
+	width int
+}
+
+// newDot returns a dot at the frame's origin that wraps
+// at the frame's margin
+func (f *Frame) newDot() *Dot {
+	d := NewDot(f.canvasOrigin(), f.margin(), f.Font)
+	d.tab = f.tabstop()
+	d.kerning = f.Kerning
+	d.leading = f.Leading * f.scale().Y
+	d.control = f.ShowControl
+	if f.Elastic {
+		d.elastic = f.tabs
+	}
+	return d
+}
+
+// margin returns the width of a line in pixels
+func (f *Frame) margin() int {
+	switch {
+	case f.WrapMode == WrapNone:
+		return nowrap
+	case f.Columns > 0:
+		return f.Columns * f.Font.ColumnWidth()
+	}
+	return f.Option.Wrap * f.scale().X
+}
+
+// tabstop returns the distance between tab stops in pixels
+func (f *Frame) tabstop() int {
+	if f.TabstopPx > 0 {
+		return f.TabstopPx * f.scale().X
+	}
+	n := f.Tabstop
+	if n <= 0 {
+		n = 4
+	}
+	dx, _ := f.Font.GlyphAdvance(' ')
+	return n * int(dx>>6)
+}
+
+// layout places every box on a line. A text box crossing the
+// margin is split so that each box lies on exactly one line
+// (after its last whitespace that fits if words are wrapped),
+// and each break box is sized for the position it lands on.
+// Boxes sharing a line are then coalesced and the line table
+// and box positions recorded. If the frame is a window onto a
+// text, the boxes below its last line are dropped.
+func (f *Frame) layout() {
+	f.tabs = nil
+	f.relayout(f.retab(0, f.Len()))
+}
+
+// relayout is like layout, but keeps the boxes and positions
+// recorded for the lines before the one preceding offset q.
+// An edit at q can't move anything before that line.
+func (f *Frame) relayout(q int) {
+	n := max(f.LineOf(q)-1, 0)
+	bn, q, hard := 0, 0, true
+	if n < len(f.lines) {
+		bn, q, hard = f.lines[n].bn, f.lines[n].q, f.lines[n].hard
+	} else {
+		n = 0
+	}
+	sp := f.canvasOrigin().Add(image.Pt(0, n*f.FontHeight()))
+
+	dot := f.newDot()
+	dot.Point = sp
+	f.full = false
+	bottom := f.bottom()
+	fit := dot.fit
+	if f.WrapMode == WrapWord {
+		// a word may span the boxes, so take the text between
+		// breaks whole
+		f.boxes.join(bn)
+		fit = dot.wordfit
+	}
+	for bn := bn; bn < len(f.boxes.Box); bn++ {
+		box := f.boxes.Box[bn]
+		if !box.IsBreak() && box.Width() > dot.remaining() {
+			n := fit(box)
+			if n == 0 && dot.Width() > 0 {
+				dot.Newline()
+				n = fit(box)
+			}
+			if n == 0 {
+				n = dot.fit(box)
+			}
+			if n == 0 {
+				// wider than the line: give it a line of its own
+				_, n = utf8.DecodeRune(box.Bytes())
+			}
+			if n < box.Len() {
+				f.boxes.Split(bn, n)
+			}
+		}
+		if sp := dot.InsertBox(box); f.text != nil && sp.Y >= bottom {
+			for _, box := range f.boxes.Box[bn:] {
+				delete(f.tabs, box)
+			}
+			f.boxes.Delete(bn, len(f.boxes.Box))
+			f.full = true
+		}
+	}
+	if dot.Y >= bottom {
+		f.full = true
+	}
+
+	dot = f.newDot()
+	dot.Point = sp
+	f.boxes.Clean(dot, bn, len(f.boxes.Box))
+	f.place(n, bn, q, hard)
+}
+
+// place records the position and offset of each box from box
+// bn on, and the lines they occupy. Box bn begins line n at
+// offset q.
+func (f *Frame) place(n, bn, q int, hard bool) {
+	f.lines = f.lines[:n]
+	f.pos = f.pos[:bn]
+	f.off = f.off[:bn]
+	dot := f.newDot()
+	dot.Point = f.canvasOrigin().Add(image.Pt(0, n*f.FontHeight()))
+	y := -1
+	for _, box := range f.Boxes()[bn:] {
+		sp := dot.InsertBox(box)
+		if sp.Y != y {
+			f.lines = append(f.lines, line{q: q, bn: bn, hard: hard})
+			y = sp.Y
+		}
+		if box.BreakChar() != '\n' {
+			l := &f.lines[len(f.lines)-1]
+			l.width = max(l.width, sp.X+box.Width()-dot.Origin().X)
+		}
+		f.pos = append(f.pos, sp)
+		f.off = append(f.off, q)
+		hard = box.BreakChar() == '\n'
+		q += box.Len()
+		bn++
+	}
+	if dot.Y != y && (f.text == nil || dot.Y < f.bottom()) {
+		f.lines = append(f.lines, line{q: q, bn: bn, hard: hard})
+	}
+	f.end = dot.Point
+}
+
+// boxAt returns the number of the box containing offset q
+// and the offset where it begins, or the number of boxes and
+// the frame's length if q is past the last box
+func (f *Frame) boxAt(q int) (bn, q0 int) {
+	bn = sort.Search(len(f.off), func(n int) bool { return f.off[n] > q }) - 1
+	if bn < 0 {
+		return 0, 0
+	}
+	if box := f.boxes.Box[bn]; q >= f.off[bn]+box.Len() {
+		return len(f.off), f.Len()
+	}
+	return bn, f.off[bn]
+}
+
+// bottom returns the y coordinate below the frame's last line
+func (f *Frame) bottom() int {
+	return f.canvasOrigin().Y + f.MaxLines()*f.FontHeight()
+}
+
+// Extent returns the width in pixels of the frame's widest
+// line. It can exceed the frame's width if lines aren't
+// wrapped.
+func (f *Frame) Extent() int {
+	return floordiv(f.extent(), f.scale().X)
+}
+
+// extent is like Extent, but measured on the canvas
+func (f *Frame) extent() (w int) {
+	for _, l := range f.lines {
+		w = max(w, l.width)
+	}
+	return w
+}
+
+// MaxLines returns the number of lines that fit in the frame
+func (f *Frame) MaxLines() int {
+	return max((f.canvas().Dy()-2*f.canvasOrigin().Y)/f.FontHeight(), 1)
+}
+
+// NumLines returns the number of visual lines in the frame. A
+// line wrapped at the margin counts as more than one.
+func (f *Frame) NumLines() int {
+	return len(f.lines)
+}
+
+// Full returns true if the frame's last line is taken. A
+// frame ending before its attached text does is always full.
+func (f *Frame) Full() bool {
+	return f.full || f.text != nil && f.org+f.Len() < f.text.Len()
+}
+
+// LineOf returns the visual line containing offset q
+func (f *Frame) LineOf(q int) int {
+	return max(sort.Search(len(f.lines), func(n int) bool { return f.lines[n].q > q })-1, 0)
+}
+
+// LineStart returns the offset of the first byte on visual
+// line n
+func (f *Frame) LineStart(n int) int {
+	if n <= 0 || len(f.lines) == 0 {
+		return 0
+	}
+	if n >= len(f.lines) {
+		return f.Len()
+	}
+	return f.lines[n].q
+}
+
+// NumLogicalLines returns the number of newline-delimited
+// lines in the frame
+func (f *Frame) NumLogicalLines() (n int) {
+	for _, l := range f.lines {
+		if l.hard {
+			n++
+		}
+	}
+	return n
+}
+
+// LogicalLineOf returns the newline-delimited line containing
+// offset q
+func (f *Frame) LogicalLineOf(q int) (n int) {
+	for _, l := range f.lines[:f.LineOf(q)+1] {
+		if l.hard {
+			n++
+		}
+	}
+	return max(n-1, 0)
+}
+
+// LogicalLineStart returns the offset of the first byte on
+// newline-delimited line n
+func (f *Frame) LogicalLineStart(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	for _, l := range f.lines {
+		if l.hard {
+			if n--; n < 0 {
+				return l.q
+			}
+		}
+	}
+	return f.Len()
+}
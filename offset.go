@@ -1,8 +1,8 @@
 package frame
 
 import (
-	"fmt"
 	"image"
+	"unicode/utf8"
 )
 
 // Origin returns the insertion point of the first
@@ -11,56 +11,95 @@ func (f *Frame) Origin() image.Point {
 	return f.Bounds().Min.Add(f.origin)
 }
 
+// XOffset returns the number of pixels the frame's text is
+// scrolled to the left
+func (f *Frame) XOffset() int {
+	return floordiv(f.xoff, f.scale().X)
+}
+
+// MaxXOffset returns the largest offset SetXOffset accepts.
+// It's zero unless lines aren't wrapped and the widest is
+// wider than the frame.
+func (f *Frame) MaxXOffset() int {
+	return floordiv(f.maxxoff(), f.scale().X)
+}
+
+// maxxoff is like MaxXOffset, but measured on the canvas
+func (f *Frame) maxxoff() int {
+	if f.WrapMode != WrapNone {
+		return 0
+	}
+	return max(f.extent()-(f.canvas().Dx()-2*f.canvasOrigin().X), 0)
+}
+
+// SetXOffset scrolls the frame's text x pixels to the left and
+// redraws the frame. The offset is clamped between zero and
+// MaxXOffset. It returns the new offset. Points passed to
+// and returned by the frame's methods are where the glyphs
+// appear after scrolling.
+func (f *Frame) SetXOffset(x int) int {
+	x = max(0, min(x*f.scale().X, f.maxxoff()))
+	if x == f.xoff {
+		return f.XOffset()
+	}
+	f.xoff = x
+	f.Redraw(f.selecting)
+	f.dirty = true
+	return f.XOffset()
+}
+
 func (f *Frame) Box(bn int) *Box {
 	return f.boxes.Box[bn]
 }
 
-func (f *Frame) IndexOf(pt image.Point) (bn, offset int) {
-	pt = f.alignY(pt)
-	dot := NewDot(f.Origin(), f.Option.Wrap, f.Font)
-	var box *Box
-	for bn, box = range f.Boxes() {
-		switch {
-		case dot.Y < pt.Y:
-			// nothing special
-		case dot.Y == pt.Y:
-			// point intersects box
-			dot.indexOf(f.Box(bn), pt)
-		case dot.Y > pt.Y:
-			// advanced too far
-			if bn-1 < 0 {
-				// bug fix for crash: happened when selecting
-				// and dragging all the way to the top
-				return bn, offset
-			}
+// IndexOf returns the offset of the glyph under pt and the
+// number of the box containing it
+func (f *Frame) IndexOf(pt image.Point) (offset, bn int) {
+	// a logical point covers several points on the canvas. The
+	// last row lies on the line the point was taken from, and
+	// the middle column is nearest the glyph's edge.
+	sc := f.scale()
+	pt = f.upscale(pt).Add(image.Pt(sc.X/2, sc.Y-1))
+	pt = f.alignY(pt.Add(image.Pt(f.xoff, 0)))
+	if pt.Y < f.canvasOrigin().Y || len(f.lines) == 0 {
+		return 0, 0
+	}
+	n := (pt.Y - f.canvasOrigin().Y) / f.FontHeight()
+	if n >= len(f.lines) {
+		return f.Len(), len(f.off)
+	}
+	end := len(f.off)
+	if n+1 < len(f.lines) {
+		end = f.lines[n+1].bn
+	}
+	dot := f.newDot()
+	for bn = f.lines[n].bn; bn < end; bn++ {
+		box, sp := f.Box(bn), f.pos[bn]
+		if pt.X < sp.X+box.Width() || box.BreakChar() == '\n' {
+			dot.Point = sp
+			return f.off[bn] + dot.indexOf(box, pt), bn
 		}
-		dot.InsertBox(box)
-		offset += box.Len()
 	}
-	return
+	return f.LineStart(n + 1), bn
 }
 
 // PointOf computes the point of origin for glyph x
 func (f *Frame) PointOf(x int) (pt image.Point) {
-	defer func() { fmt.Printf("PointOf: pt=%v x=%d\n", pt, x) }()
-	pt = f.alignY(pt)
-	dot := NewDot(f.Origin(), f.Option.Wrap, f.Font)
-	i := 0
-	bn := 0
-	var box *Box
-	for bn, box = range f.Boxes() {
-		if i+box.Len() == x {
-			return dot.Point
-		}
-		if i+box.Len() > x {
-			break
-		}
-		dot.InsertBox(box)
-		i += box.Len()
+	return f.downscale(f.pointOf(x))
+}
+
+// pointOf is like PointOf, but returns a point on the canvas
+func (f *Frame) pointOf(x int) (pt image.Point) {
+	scroll := image.Pt(f.xoff, 0)
+	bn, q := f.boxAt(x)
+	if bn == len(f.off) {
+		return f.end.Sub(scroll)
 	}
-	bn, _ = f.boxes.Find(bn-1, i, x)
-	dot.InsertBox(f.Box(bn))
-	return dot.Point
+	box, sp := f.Box(bn), f.pos[bn].Sub(scroll)
+	if box.IsBreak() {
+		return sp
+	}
+	return sp.Add(image.Pt(f.newDot().originOf(box.Bytes(), max(x-q, 0)), 0))
 }
 
 // PointOf computes the point of origin for glyph i
@@ -69,10 +108,12 @@ func (f *Frame) zPointOf(i int) (pt image.Point) {
 	if i < 0 {
 		i = 0
 	}
-	s := f.s[:i]
-	dot := NewDot(f.Origin(), f.Option.Wrap, f.Font)
-	for j := 0; j < len(s); j++ {
-		dot.Insert(rune(s[j]))
+	s := f.Slice(0, i)
+	dot := NewDot(f.canvasOrigin(), f.margin(), f.Font)
+	for j := 0; j < len(s); {
+		r, size := utf8.DecodeRune(s[j:])
+		dot.Insert(r)
+		j += size
 	}
 	return dot.Point
 }
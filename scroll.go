@@ -23,6 +23,16 @@ type Handler interface {
 	Dirty() bool
 }
 
+// Panner is a Handler that can be scrolled horizontally.
+// Sc draws a horizontal scrollbar for a Panner that has
+// somewhere to scroll. A Frame is a Panner.
+type Panner interface {
+	Extent() int
+	XOffset() int
+	MaxXOffset() int
+	SetXOffset(x int) int
+}
+
 type Sc struct {
 	disp *image.RGBA
 	src  Handler
@@ -33,7 +43,9 @@ type Sc struct {
 	width  int
 
 	Bar     image.Rectangle
+	HBar    image.Rectangle
 	holding mouse.Button
+	hbar    bool
 
 	dirty bool
 
@@ -83,6 +95,65 @@ func (s *Sc) Clicksb(pt image.Point, dir Direction) {
 	s.dirty = true
 }
 
+// Clickhsb scrolls the source horizontally in response to a
+// click at pt on the horizontal scrollbar
+func (s *Sc) Clickhsb(pt image.Point, dir Direction) {
+	p, ok := s.panner()
+	if !ok {
+		return
+	}
+	r := s.HBarBounds()
+	rat := float64(p.Extent()) / float64(r.Dx())
+	dx := int(float64(pt.X-r.Min.X) * rat)
+	x := p.XOffset()
+	switch dir {
+	case DirDown:
+		x += dx
+	case DirUp:
+		x -= dx
+	default:
+		x = dx
+	}
+	p.SetXOffset(x)
+	s.updatehbar()
+	s.dirty = true
+}
+
+// panner returns the source if it's a Panner that can be
+// scrolled, or is scrolled, horizontally
+func (s *Sc) panner() (Panner, bool) {
+	p, ok := s.src.(Panner)
+	if !ok || p.MaxXOffset() <= 0 && p.XOffset() <= 0 {
+		return nil, false
+	}
+	return p, true
+}
+
+// HBarBounds returns the area under the source where the
+// horizontal scrollbar is drawn. It's empty unless the source
+// is a Panner with lines wider than it shows.
+func (s *Sc) HBarBounds() image.Rectangle {
+	if _, ok := s.panner(); !ok {
+		return image.ZR
+	}
+	r := s.Bounds()
+	return image.Rect(s.width, r.Max.Y-s.width, r.Max.X, r.Max.Y)
+}
+
+func (s *Sc) updatehbar() {
+	p, ok := s.panner()
+	if !ok {
+		return
+	}
+	r := s.HBarBounds()
+	s.HBar = r
+	if ext := p.Extent(); ext > 0 {
+		rat := float64(r.Dx()) / float64(ext)
+		s.HBar.Min.X = r.Min.X + int(float64(p.XOffset())*rat)
+		s.HBar.Max.X = min(r.Min.X+int(float64(p.XOffset()+s.EmbedBounds().Dx())*rat), r.Max.X)
+	}
+}
+
 func (s *Sc) updatebar() {
 	r := s.Bounds()
 	sp := s.sp
@@ -92,9 +163,13 @@ func (s *Sc) updatebar() {
 	s.Bar.Max.Y = int(float64(sp.Y+s.Bounds().Dy()) * rat)
 }
 
+// Project maps the point (x, y) in the container to the
+// source. Horizontal scrolling is done by the source, so
+// the source's own coordinates already account for it.
 func (s *Sc) Project(x, y float32) (float32, float32) {
-	x += float32(-s.width)
-	y += float32(s.sp.Y)
+	r := s.EmbedBounds()
+	x += float32(s.sp.X - r.Min.X)
+	y += float32(s.sp.Y - r.Min.Y)
 	return x, y
 }
 
@@ -113,24 +188,31 @@ func (s *Sc) Handle(e interface{}) {
 		s.src.Handle(e)
 	case mouse.Event:
 		pt := image.Pt(int(e.X), int(e.Y))
-		if pt.In(s.EmbedBounds()) {
+		if pt.In(s.EmbedBounds()) && s.holding == mouse.ButtonNone {
 			e.X, e.Y = s.Project(e.X, e.Y)
 			s.src.Handle(e)
 		} else {
+			click := s.Clicksb
+			if e.Direction == mouse.DirPress {
+				s.hbar = pt.In(s.HBarBounds())
+			}
+			if s.hbar {
+				click = s.Clickhsb
+			}
 			if e.Direction == mouse.DirPress {
 				switch e.Button {
 				case mouse.ButtonLeft:
-					s.Clicksb(pt, DirUp)
+					click(pt, DirUp)
 				case mouse.ButtonRight:
-					s.Clicksb(pt, DirDown)
+					click(pt, DirDown)
 				case mouse.ButtonMiddle:
-					s.Clicksb(pt, DirNone)
+					click(pt, DirNone)
 				}
 				s.holding = e.Button
 			} else if e.Direction == mouse.DirRelease {
 				s.holding = mouse.ButtonNone
 			} else if s.holding == mouse.ButtonMiddle {
-				s.Clicksb(pt, DirNone)
+				click(pt, DirNone)
 			}
 		}
 		return
@@ -147,6 +229,12 @@ func (s *Sc) Draw() {
 	if s.src.Dirty() {
 		s.src.Draw()
 	}
+	if hr := s.HBarBounds(); hr != image.ZR {
+		s.updatehbar()
+		draw.Draw(s.disp, hr, s.FrameColor, image.ZP, draw.Src)
+		draw.Draw(s.disp, s.HBar, s.BarColor, image.ZP, draw.Src)
+		drawBorder(s.disp, hr, GrayColors.Back, image.ZP, 2)
+	}
 	draw.Draw(s.disp, s.EmbedBounds(), s.src.RGBA(), s.sp, draw.Src)
 	s.dirty = false
 }
@@ -161,7 +249,7 @@ func mul(r image.Rectangle, rat float64) image.Rectangle {
 
 func (s *Sc) EmbedBounds() image.Rectangle {
 	r := s.Bounds()
-	return image.Rect(s.width+1, 0, r.Max.X+s.width, r.Max.Y)
+	return image.Rect(s.width+1, 0, r.Max.X+s.width, r.Max.Y-s.HBarBounds().Dy())
 }
 
 func (s *Sc) Bounds() image.Rectangle {
@@ -23,10 +23,6 @@ type Select struct {
 	a int
 	b int
 
-	// cache of points for a,b
-	at image.Point
-	bt image.Point
-
 	res Resolver
 
 	// avoid redrawing the entire selection on the
@@ -45,7 +41,6 @@ func NewSelect(r image.Rectangle, img *image.RGBA, color image.Image, res Resolv
 
 func (s *Select) Open(i int) {
 	s.a = i
-	s.at = s.res.PointOf(i)
 	s.b = i
 }
 
@@ -56,7 +51,6 @@ func (s *Select) Seek(offset int64, whence int) (int64, error) {
 
 func (s *Select) Close() {
 	s.a, s.b = 0, 0
-	s.at, s.bt = image.ZP, image.ZP
 	s.Clear()
 
 	//
@@ -71,8 +65,9 @@ func (s *Select) Clear() {
 //
 // Rects returns the rectangles representing the active selection. cap(r) == 3
 func (s *Select) Rects() (r []image.Rectangle) {
-	fmt.Printf("%v,%v\n", s.at, s.bt)
-	return s.rects(s.at, s.bt)
+	at, bt := s.Sp(), s.Ep()
+	fmt.Printf("%v,%v\n", at, bt)
+	return s.rects(at, bt)
 }
 
 func (s *Select) DeltaRects() (r []image.Rectangle) {
@@ -199,8 +194,10 @@ func (s *Select) Update(j int) {
 	if abs(b-c) < 1 {
 		return
 	}
-	pt := s.res.PointOf
-	at, bt, ct := pt(a), pt(b), pt(c)
+	// the points are resolved on every update, since the text
+	// under them moves when the frame is edited, scrolled or
+	// resized
+	at, bt, ct := s.res.PointOf(a), s.res.PointOf(b), s.res.PointOf(c)
 	switch {
 	case a <= b && b < c: // down
 		s.update3(bt, ct, false)
@@ -218,7 +215,6 @@ func (s *Select) Update(j int) {
 		s.update3(at, ct, false)
 	}
 	s.b = c
-	s.bt = ct
 }
 
 func max(a, b int) int {
@@ -264,38 +260,10 @@ func (s *Select) Sweep(j int) {
 
 	}
 	fmt.Fprintf(os.Stderr, "\n")
-	return
-	a := s.a
-	b := s.b
-	c := j
-	if abs(b-c) < 1 {
-		return
-	}
-	bg, erase := s.Color, image.Transparent
-	pt := s.res.PointOf
-	at, bt, ct := pt(a), pt(b), pt(c)
-	switch {
-	case a <= b && b < c: // down
-		s.Draw(bt, ct, bg)
-	case a <= c && c < b: // down and up
-		s.Draw(ct, bt, erase)
-	case c < a && a <= b: // down and up over
-		s.Draw(at, bt, erase)
-		s.Draw(ct, at, bg)
-	case c < b && b <= a: // up
-		s.Draw(ct, bt, bg)
-	case b < c && c < a: // up and down
-		s.Draw(bt, ct, erase)
-	case b < a && a < c: // up and down over
-		s.Draw(bt, at, erase)
-		s.Draw(at, ct, bg)
-	}
-	s.b = c
-	s.bt = ct
 }
 
-func (s Select) Sp() image.Point { return s.at }
-func (s Select) Ep() image.Point { return s.bt }
+func (s Select) Sp() image.Point { return s.res.PointOf(s.a) }
+func (s Select) Ep() image.Point { return s.res.PointOf(s.b) }
 func (s Select) Addr() (i, j int) {
 	return s.a, s.b
 }
@@ -0,0 +1,181 @@
+package frame
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"sort"
+)
+
+// Style is the foreground and background of a run of text. A
+// nil field is taken from the frame's Colors.
+type Style struct {
+	Text, Back image.Image
+}
+
+// Run is a style applied to the bytes [I, J)
+type Run struct {
+	Range
+	Style
+}
+
+// runs is a list of disjoint runs sorted by offset. Its
+// offsets are measured from the start of the frame's text, so
+// the runs stay put when the frame's origin moves.
+type runs []Run
+
+// SetStyle applies st to the bytes [i, j), replacing the style
+// of any part of the range that already has one. Like other
+// offsets, i and j are relative to the frame's origin, but
+// they may lie outside the frame if it has text attached.
+// Text inserted inside a run takes on its style.
+func (f *Frame) SetStyle(i, j int, st Style) {
+	if i > j {
+		i, j = j, i
+	}
+	i, j = max(i+f.org, 0), max(j+f.org, 0)
+	f.styles.cut(i, j)
+	if i == j {
+		return
+	}
+	n := sort.Search(len(f.styles), func(n int) bool { return f.styles[n].I >= j })
+	f.styles = append(f.styles, Run{})
+	copy(f.styles[n+1:], f.styles[n:])
+	f.styles[n] = Run{Range{i, j}, st}
+	f.markstyle(i, j)
+}
+
+// ClearStyle removes the style of the bytes [i, j), so they
+// are drawn in the frame's Colors
+func (f *Frame) ClearStyle(i, j int) {
+	if i > j {
+		i, j = j, i
+	}
+	i, j = max(i+f.org, 0), max(j+f.org, 0)
+	f.styles.cut(i, j)
+	f.markstyle(i, j)
+}
+
+// StyleAt returns the style of the byte at offset q, and false
+// if the byte has none
+func (f *Frame) StyleAt(q int) (Style, bool) {
+	q += f.org
+	n := f.styles.find(q)
+	if n == len(f.styles) || f.styles[n].I > q {
+		return Style{}, false
+	}
+	return f.styles[n].Style, true
+}
+
+// markstyle marks the part of the text offsets [i, j) in the
+// frame dirty
+func (f *Frame) markstyle(i, j int) {
+	i, j = i-f.org, j-f.org
+	if j > 0 && i < f.Len() {
+		f.MarkRange(max(i, 0), min(j, f.Len()))
+		f.dirty = true
+	}
+}
+
+// style returns the style drawn at text offset q, with the
+// frame's colors in place of nil fields, and the offset where
+// it ends, which is no more than end
+func (f *Frame) style(q, end int) (st Style, j int) {
+	j = end
+	if n := f.styles.find(q); n < len(f.styles) {
+		if r := f.styles[n]; r.I <= q {
+			st, j = r.Style, min(r.J, end)
+		} else {
+			j = min(r.I, end)
+		}
+	}
+	if st.Text == nil {
+		st.Text = f.Colors.Text
+	}
+	if st.Back == nil {
+		st.Back = f.Colors.Back
+	}
+	return st, j
+}
+
+// find returns the index of the first run ending after q
+func (r runs) find(q int) int {
+	return sort.Search(len(r), func(n int) bool { return r[n].J > q })
+}
+
+// cut removes the bytes [i, j) from the runs, splitting the
+// runs that straddle either end
+func (r *runs) cut(i, j int) {
+	if i == j {
+		return
+	}
+	var out runs
+	for _, v := range *r {
+		if v.J <= i || v.I >= j {
+			out = append(out, v)
+			continue
+		}
+		if v.I < i {
+			out = append(out, Run{Range{v.I, i}, v.Style})
+		}
+		if v.J > j {
+			out = append(out, Run{Range{j, v.J}, v.Style})
+		}
+	}
+	*r = out
+}
+
+// insert makes room for n bytes inserted at q. A run
+// containing q grows to cover them.
+func (r runs) insert(q, n int) {
+	for k := range r {
+		v := &r[k]
+		if v.I >= q {
+			v.I += n
+			v.J += n
+		} else if v.J > q {
+			v.J += n
+		}
+	}
+}
+
+// delete removes the bytes [i, j) from the runs, dropping the
+// runs left empty
+func (r *runs) delete(i, j int) {
+	at := func(q int) int {
+		switch {
+		case q <= i:
+			return q
+		case q >= j:
+			return q - (j - i)
+		}
+		return i
+	}
+	out := (*r)[:0]
+	for _, v := range *r {
+		v.I, v.J = at(v.I), at(v.J)
+		if v.I < v.J {
+			out = append(out, v)
+		}
+	}
+	*r = out
+}
+
+// writestyle writes the styles of box bn and where they
+// change to w
+func (f *Frame) writestyle(w io.Writer, bn int) {
+	q := f.org + f.off[bn]
+	end := q + f.Box(bn).Len()
+	for a, b := q, q; a < end; a = b {
+		var st Style
+		st, b = f.style(a, end)
+		fmt.Fprint(w, b-q)
+		for _, img := range [...]image.Image{st.Text, st.Back} {
+			if u, ok := img.(*image.Uniform); ok {
+				fmt.Fprint(w, u.C)
+			} else {
+				fmt.Fprintf(w, "%p", img)
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package frame
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// Text is a document that a frame displays a window onto. A
+// frame with Text attached holds a copy of the bytes from
+// its origin that fit on its lines, and forwards its edits
+// to the Text. *Boxes is a Text.
+type Text interface {
+	io.ReaderAt
+	InsertAt(p []byte, off int64) (n int, err error)
+	DeleteRange(i, j int)
+	Len() int
+}
+
+// SetText attaches t to the frame and fills the frame from
+// the start of t. A nil t detaches the current text, leaving
+// the frame's contents in place.
+func (f *Frame) SetText(t Text) {
+	f.text = t
+	if t != nil {
+		f.SetOrigin(0)
+	}
+}
+
+// Org returns the offset in the attached text of the first
+// byte in the frame
+func (f *Frame) Org() int {
+	return f.org
+}
+
+// SetOrigin makes offset q of the attached text the first byte
+// in the frame and lays out as many lines from there as fit
+// in the frame. An offset inside a rune is moved back to the
+// rune's first byte. It returns the number of bytes the frame
+// accepted. Offsets passed to and returned by the frame's
+// methods remain relative to the origin.
+func (f *Frame) SetOrigin(q int) int {
+	if f.text == nil {
+		return f.Len()
+	}
+	q = f.runestart(max(0, min(q, f.text.Len())))
+	f.org = q
+	f.boxes = NewBoxes(f.measure)
+	f.lines, f.pos, f.off, f.tabs = nil, nil, nil, nil
+	f.full = false
+	f.fill()
+	f.MarkRange(0, f.Len())
+	f.dirty = true
+	return f.Len()
+}
+
+// runestart returns the offset of the first byte of the rune
+// in the attached text holding offset q
+func (f *Frame) runestart(q int) int {
+	var b [1]byte
+	for n := 1; n < utf8.UTFMax && q > 0; n++ {
+		if _, err := f.text.ReadAt(b[:], int64(q)); err != nil || utf8.RuneStart(b[0]) {
+			break
+		}
+		q--
+	}
+	return q
+}
+
+// fill appends bytes from the attached text to the frame
+// until the frame is full or the text runs out
+func (f *Frame) fill() {
+	if f.text == nil {
+		return
+	}
+	var buf [4096]byte
+	for !f.full {
+		q := f.org + f.Len()
+		n := min(f.text.Len()-q, len(buf))
+		if n <= 0 {
+			return
+		}
+		n, _ = f.text.ReadAt(buf[:n], int64(q))
+		p := buf[:n]
+		if q+n < f.text.Len() {
+			p = fullrunes(p)
+		}
+		if len(p) == 0 {
+			return
+		}
+		nb := f.Len()
+		f.boxes.Insert(p, nb)
+		f.relayout(f.retab(nb, f.Len()))
+		if f.Len() == nb {
+			return
+		}
+	}
+}
+
+// fullrunes returns p without a trailing partial rune
+func fullrunes(p []byte) []byte {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return p[:i]
+			}
+			break
+		}
+	}
+	return p
+}
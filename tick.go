@@ -7,6 +7,7 @@ import (
 	"image/color"
 	"image/draw"
 	"io"
+	"unicode/utf8"
 )
 
 type Tick struct {
@@ -96,9 +97,9 @@ func (t *Tick) draw(x, y, xx, yy int, bg image.Image) {
 
 func (t *Tick) Draw() error {
 	if false && t.P1 == t.P0 {
-		pt := t.Fr.PointOf(t.P1)
+		pt := t.Fr.pointOf(t.P1)
 		r := image.Rect(0, 0, 2, t.Fr.FontHeight()).Add(pt)
-		draw.Draw(t.Fr.RGBA(), r, t.Fr.Colors.Text, image.ZP, draw.Over)
+		draw.Draw(t.Fr.disp, r, t.Fr.Colors.Text, image.ZP, draw.Over)
 	}
 	// assuming the underlying selection is already
 	// drawn on t.Img, see Sweep.
@@ -113,7 +114,8 @@ func (t *Tick) Draw() error {
 		//	}
 	}
 	for i, r := range t.Pen[0].R {
-		draw.Draw(t.Fr.RGBA(), r, tickBlue, image.ZP, draw.Over)
+		draw.Draw(t.Fr.disp, t.Fr.upscaleRect(r), tickBlue, image.ZP, draw.Over)
+		t.Fr.damaged(t.Fr.upscaleRect(r))
 		t.Pen[0].R[i] = image.ZR
 	}
 	return nil
@@ -121,6 +123,9 @@ func (t *Tick) Draw() error {
 
 func (t *Tick) Insert(p []byte) (err error) {
 	if t.P1 != t.P0 {
+		// replacing the selection is undone in one step
+		t.Fr.Begin()
+		defer t.Fr.End()
 		t.Delete()
 	}
 	if len(p) == 0 {
@@ -140,9 +145,9 @@ func (t *Tick) Delete() (err error) {
 	// Either act like the delete button or erase the
 	// contents of an active selectiond
 	if t.P0 == t.P1 {
-		t.P0--
-		t.Fr.Delete(t.P0, t.P1)
-		t.P1--
+		p0 := t.Fr.boxes.PrevRune(t.P0)
+		t.Fr.Delete(p0, t.P1)
+		t.P0, t.P1 = p0, p0
 	} else {
 		if t.P0 > t.P1 {
 			t.P0, t.P1 = t.P1, t.P0
@@ -154,25 +159,35 @@ func (t *Tick) Delete() (err error) {
 	return nil
 }
 
-func (t *Tick) ReadByte() byte {
+// ReadByte returns the first byte of the selection
+func (t *Tick) ReadByte() (byte, error) {
 	var b [1]byte
-	t.Read(b[:])
-	return b[0]
+	_, err := t.Read(b[:])
+	return b[0], err
 }
+
+// first returns the first byte of the selection, or zero
+// if the selection is empty
+func (t *Tick) first() byte {
+	b, _ := t.ReadByte()
+	return b
+}
+
 func (t *Tick) WriteRune(r rune) (err error) {
-	return t.Insert([]byte{byte(r)})
+	var p [utf8.UTFMax]byte
+	return t.Insert(p[:utf8.EncodeRune(p[:], r)])
 }
 
 func (t *Tick) ck() {
 	if t.P1 < t.P0 {
 		t.P0, t.P1 = t.P1, t.P0
 	}
-	nb := len(t.Fr.s)
-	if t.P1 >= nb {
-		t.P1 = nb - 1
+	nb := t.Fr.Len()
+	if t.P1 > nb {
+		t.P1 = nb
 	}
-	if t.P0 >= nb {
-		t.P0 = nb - 1
+	if t.P0 > nb {
+		t.P0 = nb
 	}
 	if t.P1 < 0 {
 		t.P1 = 0
@@ -189,7 +204,7 @@ func (t *Tick) Size() int {
 func (t *Tick) String() string {
 	t.ck()
 	println("p0, p1", t.P0, t.P1)
-	return string(t.Fr.s[t.P0:t.P1])
+	return string(t.Fr.Slice(t.P0, t.P1))
 }
 
 func (t *Tick) Read(p []byte) (n int, err error) {
@@ -197,8 +212,7 @@ func (t *Tick) Read(p []byte) (n int, err error) {
 		return 0, io.EOF
 	}
 	t.ck()
-	q := t.Fr.s[t.P0:t.P1]
-	return copy(p, q), nil
+	return t.Fr.ReadAt(p[:min(len(p), t.P1-t.P0)], int64(t.P0))
 }
 
 func (t *Tick) Write(p []byte) (n int, err error) {
@@ -218,7 +232,7 @@ func (t *Tick) Selected() bool {
 func (t *Tick) Next() {
 	fmt.Printf("Next(): %#v\n", t.String())
 	s := []byte(t.String())
-	i := t.find(s, t.P1, len(t.Fr.s), false)
+	i := t.find(s, t.P1, t.Fr.Len(), false)
 	if i == -1 {
 		i = t.find(s, 0, t.P1, false)
 	}
@@ -231,7 +245,7 @@ func (t *Tick) Next() {
 }
 
 func (t *Tick) Find(p []byte, back bool) int {
-	return t.find(p, t.P1, len(t.Fr.s), back)
+	return t.find(p, t.P1, t.Fr.Len(), back)
 }
 
 func (t *Tick) find(p []byte, i, j int, back bool) int {
@@ -239,7 +253,7 @@ func (t *Tick) find(p []byte, i, j int, back bool) int {
 		panic("unimplemented")
 	}
 	//fmt.Printf("debug: find: %q check frame[%d:]\n", p, t.P1)
-	x := bytes.Index(t.Fr.s[i:j], p)
+	x := bytes.Index(t.Fr.Slice(i, j), p)
 	if x == -1 {
 		return -1
 	}
@@ -263,13 +277,13 @@ func isany(b byte, s []byte) bool {
 }
 func (t *Tick) FindAlpha(i int) (int, int) {
 	j := i
-	for ; i != 0 && isany(t.ReadByte(), AlphaNum); i-- {
+	for ; i != 0 && isany(t.first(), AlphaNum); i-- {
 		t.P0--
 		t.P1--
 	}
 	t.Open(j)
 	t.P1 = j + 1
-	for ; j != t.Fr.nbytes && isany(t.ReadByte(), AlphaNum); j++ {
+	for ; j != t.Fr.Len() && isany(t.first(), AlphaNum); j++ {
 		t.P1++
 		t.P0++
 	}
@@ -284,7 +298,7 @@ func (t *Tick) FindSpecial(i int) (int, int) {
 	t.Open(i - 1)
 	t.Sweep(i)
 	t.Commit()
-	if t.ReadByte() == '\n' {
+	if t.first() == '\n' {
 		return i, t.FindOrEOF([]byte{'\n'})
 	}
 	if x := t.FindQuote(); x != -1 {
@@ -293,7 +307,7 @@ func (t *Tick) FindSpecial(i int) (int, int) {
 	if x := t.FindParity(); x != -1 {
 		return i, x
 	}
-	if isany(t.ReadByte(), AlphaNum) {
+	if isany(t.first(), AlphaNum) {
 		return t.FindAlpha(i)
 	}
 	return i, -1
@@ -302,13 +316,13 @@ func (t *Tick) FindSpecial(i int) (int, int) {
 func (t *Tick) FindOrEOF(p []byte) int {
 	i := t.Find(p, false)
 	if i == -1 {
-		return t.Fr.nbytes
+		return t.Fr.Len()
 	}
 	return i
 }
 
 func (t *Tick) FindQuote() int {
-	b := t.ReadByte()
+	b := t.first()
 	for _, v := range Free {
 		if b != v {
 			continue
@@ -332,13 +346,13 @@ func (t *Tick) findParity(l byte, r byte, back bool) int {
 	if back {
 		panic("unimplemented")
 	}
-	b := t.ReadByte()
+	b := t.first()
 	if b != l {
 		return -1
 	}
 	push := 1
 	//j := -1
-	for i, v := range t.Fr.s[t.P1:] {
+	for i, v := range t.Fr.Slice(t.P1, t.Fr.Len()) {
 		if v == l {
 			println("\n\n++\n\n")
 			push++
@@ -1,12 +1,16 @@
 package frame
 
 import (
-	//	"golang.org/x/image/math/f64"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
 	"image"
+	"image/draw"
 )
 
+// Identity is the matrix that leaves an image as it is
+var Identity = f64.Aff3{1, 0, 0, 0, 1, 0}
+
 // Scale returns the matrix for scaling an image
-/*
 func Scale(w, h float64) f64.Aff3 {
 	return f64.Aff3{w, 0, 0, 0, h, 0}
 }
@@ -21,10 +25,104 @@ func ST(p image.Point, wh image.Point) f64.Aff3 {
 		0, h, h * y,
 	}
 }
-*/
+
+// Compose returns the matrix that transforms by b and then
+// by a
+func Compose(a, b f64.Aff3) f64.Aff3 {
+	return f64.Aff3{
+		a[0]*b[0] + a[1]*b[3], a[0]*b[1] + a[1]*b[4], a[0]*b[2] + a[1]*b[5] + a[2],
+		a[3]*b[0] + a[4]*b[3], a[3]*b[1] + a[4]*b[4], a[3]*b[2] + a[4]*b[5] + a[5],
+	}
+}
+
+const (
+	minZoom = 0.25
+	maxZoom = 8
+)
+
+// Zoom returns the frame's magnification
+func (f *Frame) Zoom() float64 {
+	return f.zoomm()[0]
+}
+
+// ZoomBy magnifies the frame by z, keeping the point at where
+// it is. A z less than one zooms out. The magnification is
+// kept between 1/4 and 8. The frame's text is laid out as
+// before and its canvas transformed, so offsets, points and
+// selections keep their meaning.
+func (f *Frame) ZoomBy(z float64, at image.Point) {
+	m := f.zoomm()
+	z = min64(max64(m[0]*z, minZoom), maxZoom) / m[0]
+	pt := f.upscale(at)
+	x, y := float64(pt.X), float64(pt.Y)
+	m = Compose(Translate(x, y), Compose(Scale(z, z), Compose(Translate(-x, -y), m)))
+	if m[0] > 1-1e-9 && m[0] < 1+1e-9 && m[2] == 0 && m[5] == 0 {
+		m = Identity
+	}
+	f.zoom = m
+	f.dirty = true
+	f.damaged(f.disp.Bounds())
+}
+
+// ResetZoom undoes the frame's magnification
+func (f *Frame) ResetZoom() {
+	f.zoom = Identity
+	f.dirty = true
+	f.damaged(f.disp.Bounds())
+}
+
+// zoomm returns the zoom matrix, treating the zero matrix as
+// the identity
+func (f *Frame) zoomm() f64.Aff3 {
+	if f.zoom == (f64.Aff3{}) {
+		return Identity
+	}
+	return f.zoom
+}
+
+func (f *Frame) zoomed() bool {
+	return f.zoomm() != Identity
+}
+
+// unzoom maps the point (x, y) in the zoomed frame to where it
+// lies in the frame before zooming
+func (f *Frame) unzoom(x, y float32) (float32, float32) {
+	m := f.zoomm()
+	s := f.scale()
+	x = (x*float32(s.X) - float32(m[2])) / float32(m[0]) / float32(s.X)
+	y = (y*float32(s.Y) - float32(m[5])) / float32(m[4]) / float32(s.Y)
+	return x, y
+}
+
+// drawzoom transforms the canvas onto the zoomed view
+func (f *Frame) drawzoom() {
+	if !f.zoomed() {
+		return
+	}
+	if f.view == nil || f.view.Bounds() != f.disp.Bounds() {
+		f.view = image.NewRGBA(f.disp.Bounds())
+	}
+	draw.Draw(f.view, f.view.Bounds(), f.Colors.Back, image.ZP, draw.Src)
+	xdraw.ApproxBiLinear.Transform(f.view, f.zoomm(), f.disp, f.disp.Bounds(), xdraw.Over, nil)
+	f.damage = append(f.damage[:0], f.view.Bounds())
+}
+
+func min64(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
 
 func (f *Frame) alignY(pt image.Point) image.Point {
-	return alignY(f.FontHeight(), f.Origin(), pt)
+	return alignY(f.FontHeight(), f.canvasOrigin(), pt)
 }
 func alignY(height int, origin, pt image.Point) image.Point {
 	op := origin
@@ -34,13 +132,54 @@ func alignY(height int, origin, pt image.Point) image.Point {
 	return pt
 }
 
+// scale returns the scale factor, treating zeroes as one
+func (o Option) scale() image.Point {
+	s := o.Scale
+	if s.X <= 0 {
+		s.X = 1
+	}
+	if s.Y <= 0 {
+		s.Y = 1
+	}
+	return s
+}
+
+// upscale converts the logical point pt to a point on the
+// canvas
 func (f *Frame) upscale(pt image.Point) image.Point {
-	//pt.X *= f.Scale.X
-	//pt.Y *= f.Scale.Y
+	s := f.scale()
+	pt.X *= s.X
+	pt.Y *= s.Y
 	return pt
 }
+
+// downscale converts the point pt on the canvas to the logical
+// point covering it
 func (f *Frame) downscale(pt image.Point) image.Point {
-	//pt.X /= f.Scale.X
-	//pt.Y /= f.Scale.Y
+	s := f.scale()
+	pt.X = floordiv(pt.X, s.X)
+	pt.Y = floordiv(pt.Y, s.Y)
 	return pt
 }
+
+func (f *Frame) upscaleRect(r image.Rectangle) image.Rectangle {
+	return image.Rectangle{f.upscale(r.Min), f.upscale(r.Max)}
+}
+
+// canvas returns the bounds of the image the frame draws on
+func (f *Frame) canvas() image.Rectangle {
+	return image.Rectangle{image.ZP, f.upscale(f.size)}
+}
+
+// canvasOrigin returns the point on the canvas where the
+// first glyph is drawn
+func (f *Frame) canvasOrigin() image.Point {
+	return f.upscale(f.Origin())
+}
+
+func floordiv(a, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
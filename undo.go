@@ -0,0 +1,181 @@
+package frame
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// edit is an insertion or deletion of p at offset q of the
+// frame's text
+type edit struct {
+	insert bool
+	q      int
+	p      []byte
+}
+
+// txn is a group of edits undone and redone together. sel
+// holds the tick's selection from before the edits.
+type txn struct {
+	edits  []edit
+	sel    Range
+	typing bool
+}
+
+// journal records the edits made to a frame
+type journal struct {
+	undo, redo []*txn
+	cur        *txn
+	depth      int
+	replay     bool
+}
+
+// Begin starts a group of edits that are undone and redone as
+// one. Groups may nest; the outermost End closes the group.
+func (f *Frame) Begin() {
+	if f.hist.depth == 0 {
+		f.closetxn()
+	}
+	f.hist.depth++
+}
+
+// End closes the group started by the matching Begin
+func (f *Frame) End() {
+	if f.hist.depth == 0 {
+		return
+	}
+	if f.hist.depth--; f.hist.depth == 0 {
+		f.closetxn()
+	}
+}
+
+// Undo reverts the most recent group of edits and restores the
+// tick's selection from before them. It returns false if there
+// is nothing to undo.
+func (f *Frame) Undo() bool {
+	f.closetxn()
+	h := &f.hist
+	if len(h.undo) == 0 {
+		return false
+	}
+	t := h.undo[len(h.undo)-1]
+	h.undo = h.undo[:len(h.undo)-1]
+	for i := len(t.edits) - 1; i >= 0; i-- {
+		e := t.edits[i]
+		f.apply(edit{insert: !e.insert, q: e.q, p: e.p})
+	}
+	h.redo = append(h.redo, t)
+	f.restore(t.sel)
+	return true
+}
+
+// Redo reapplies the most recently undone group of edits and
+// places the tick after the last of them. It returns false if
+// there is nothing to redo.
+func (f *Frame) Redo() bool {
+	h := &f.hist
+	if len(h.redo) == 0 {
+		return false
+	}
+	t := h.redo[len(h.redo)-1]
+	h.redo = h.redo[:len(h.redo)-1]
+	for _, e := range t.edits {
+		f.apply(e)
+	}
+	h.undo = append(h.undo, t)
+	e := t.edits[len(t.edits)-1]
+	if e.insert {
+		e.q += len(e.p)
+	}
+	f.restore(Range{e.q, e.q})
+	return true
+}
+
+// record adds an edit to the journal. Typing and backspacing
+// at the end of the previous edit are coalesced with it.
+func (f *Frame) record(insert bool, i int, p []byte) {
+	h := &f.hist
+	if h.replay || len(p) == 0 {
+		return
+	}
+	e := edit{insert: insert, q: f.org + i, p: append([]byte{}, p...)}
+	if h.depth == 0 && !f.continues(e) {
+		f.closetxn()
+	}
+	if h.cur == nil {
+		h.cur = &txn{sel: f.selection(), typing: h.depth == 0}
+	}
+	h.cur.edits = append(h.cur.edits, e)
+	h.redo = nil
+}
+
+// continues returns true if e extends the open typing group:
+// a single rune typed after the last insertion, or deleted
+// before the last deletion. A newline ends the group.
+func (f *Frame) continues(e edit) bool {
+	t := f.hist.cur
+	if t == nil || !t.typing || len(e.p) > utf8.UTFMax || bytes.IndexByte(e.p, '\n') >= 0 {
+		return false
+	}
+	last := t.edits[len(t.edits)-1]
+	if bytes.IndexByte(last.p, '\n') >= 0 {
+		return false
+	}
+	if e.insert {
+		return last.insert && e.q == last.q+len(last.p)
+	}
+	return !last.insert && e.q+len(e.p) == last.q
+}
+
+// closetxn moves the open group onto the undo stack
+func (f *Frame) closetxn() {
+	h := &f.hist
+	if h.cur == nil {
+		return
+	}
+	h.undo = append(h.undo, h.cur)
+	h.cur = nil
+}
+
+// apply performs e without recording it. Edits outside the
+// frame's window are made on the attached text directly.
+func (f *Frame) apply(e edit) {
+	f.hist.replay = true
+	defer func() { f.hist.replay = false }()
+	i, j := e.q-f.org, e.q-f.org+len(e.p)
+	if f.text != nil && (i < 0 || i > f.Len() || !e.insert && j > f.Len()) {
+		if e.insert {
+			f.text.InsertAt(e.p, int64(e.q))
+			f.styles.insert(e.q, len(e.p))
+		} else {
+			f.text.DeleteRange(e.q, e.q+len(e.p))
+			f.styles.delete(e.q, e.q+len(e.p))
+		}
+		f.SetOrigin(f.org)
+		return
+	}
+	if e.insert {
+		f.Insert(e.p, i)
+	} else {
+		f.Delete(i, j)
+	}
+}
+
+// selection returns the tick's selection as offsets in the
+// frame's text
+func (f *Frame) selection() Range {
+	if f.Tick == nil {
+		return Range{}
+	}
+	return Range{f.org + f.Tick.P0, f.org + f.Tick.P1}
+}
+
+// restore moves the tick's selection to r
+func (f *Frame) restore(r Range) {
+	if f.Tick == nil {
+		return
+	}
+	p0 := max(0, min(r.I-f.org, f.Len()))
+	p1 := max(0, min(r.J-f.org, f.Len()))
+	f.Tick.Open(p0)
+	f.Tick.P1 = p1
+}